@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/clients"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/collectors"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/config"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/tlsconfig"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/utils"
 	"log"
 	"os"
@@ -13,9 +17,16 @@ import (
 )
 
 const (
-	defaultPoll   = 2 * time.Second
-	defaultReport = 10 * time.Second
-	defaultLimit  = 1
+	defaultPoll    = 2 * time.Second
+	defaultReport  = 10 * time.Second
+	defaultLimit   = 1
+	defaultHashAlg = string(hash.Legacy)
+
+	// hashAlgUsage - agent signs with legacy scheme by default, because servers which aren't upgraded check only it
+	hashAlgUsage = "Hash algorithm, hmac-sha256 or hmac-sha512 only after servers are upgraded"
+
+	// shutdownTimeout - time given to in-flight tasks and final upload on exit
+	shutdownTimeout = 10 * time.Second
 )
 
 var (
@@ -26,20 +37,52 @@ var (
 	flPoll       *time.Duration // POLL_INTERVAL
 	flReport     *time.Duration // REPORT_INTERVAL
 	flKey        *string        // KEY
+	flKeyID      *string        // KEY_ID
+	flKeyring    *string        // KEYRING
+	flHashAlg    *string        // HASH_ALGORITHM
 	flLimit      *int           // RATE_LIMIT
 	flCrypto     *string        // CRYPTO_KEY
 	flConfig     *bool          // CONFIG
+	flTLSCA      *string        // TLS_CA
+	flTLSCert    *string        // TLS_CERT
+	flTLSKey     *string        // TLS_KEY
+	flToken      *string        // TOKEN
+	flAgentID    *string        // AGENT_ID
+	flLocalHTTP  *string        // LOCAL_HTTP
+	flLocalUDP   *string        // LOCAL_UDP
+	flTelemetry  *string        // TELEMETRY_ADDRESS
 )
 
+// hostname - default agent ID.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return name
+}
+
 func parseFlags() {
 	log.Println("agent init...")
 	flAddr = flag.String("a", utils.DefaultAddress, "Server IP address")          // ADDRESS
 	flPoll = flag.Duration("p", defaultPoll, "Interval of polling metrics")       // POLL_INTERVAL
 	flReport = flag.Duration("r", defaultReport, "Interval of reporting metrics") // REPORT_INTERVAL
 	flKey = flag.String("k", "", "Hash key")                                      // KEY
+	flKeyID = flag.String("key-id", "", "ID of hash key")                         // KEY_ID
+	flKeyring = flag.String("keyring", "", "Path to hash keyring json file")      // KEYRING
+	flHashAlg = flag.String("hash-alg", defaultHashAlg, hashAlgUsage)             // HASH_ALGORITHM
 	flLimit = flag.Int("l", defaultLimit, "Limit of requests rate")               // RATE_LIMIT
 	flCrypto = flag.String("crypto-key", "", "Path to public crypto key")         // CRYPTO_KEY
 	flConfig = flag.Bool("config", false, "Configuration by config json file")    // CONFIG
+	flTLSCA = flag.String("tls-ca", "", "Path to server CA bundle")               // TLS_CA
+	flTLSCert = flag.String("tls-cert", "", "Path to client TLS certificate")     // TLS_CERT
+	flTLSKey = flag.String("tls-key", "", "Path to client TLS private key")       // TLS_KEY
+	flToken = flag.String("token", "", "API bearer token")                        // TOKEN
+	flAgentID = flag.String("agent-id", hostname(), "Agent ID sent to server")    // AGENT_ID
+	flLocalHTTP = flag.String("local-http", "", "Local http ingest address")      // LOCAL_HTTP
+	flLocalUDP = flag.String("local-udp", "", "Local udp ingest address")         // LOCAL_UDP
+	flTelemetry = flag.String("telemetry", "", "Local telemetry address")         // TELEMETRY_ADDRESS
 	flag.Parse()
 }
 
@@ -49,7 +92,7 @@ func main() {
 	log.Println("Build commit:", buildCommit)
 	parseFlags()
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
 
 	conf := utils.UpdateBoolVar(
 		"CONFIG",
@@ -94,21 +137,25 @@ func main() {
 		}
 	}
 
-	// Creating poll and report intervals
-	pollInterval := time.NewTicker(
-		utils.UpdateDurVar(
-			"POLL_INTERVAL",
-			flPoll,
-			cPoll,
-		),
+	// Poll interval is used by collectors which have no own interval in config
+	pollInterval := utils.UpdateDurVar(
+		"POLL_INTERVAL",
+		flPoll,
+		cPoll,
 	)
-	reportInterval := time.NewTicker(
-		utils.UpdateDurVar(
-			"REPORT_INTERVAL",
-			flReport,
-			cReport,
-		),
+	scheduled, err := collectors.Default().Build(configuration.Collectors, pollInterval)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Creating report interval, upload which isn't done till the next report is cancelled
+	report := utils.UpdateDurVar(
+		"REPORT_INTERVAL",
+		flReport,
+		cReport,
 	)
+	reportInterval := time.NewTicker(report)
 
 	key := utils.UpdateStringVar(
 		"KEY",
@@ -116,6 +163,33 @@ func main() {
 		configuration.Key,
 	)
 
+	keyID := utils.UpdateStringVar(
+		"KEY_ID",
+		flKeyID,
+		configuration.KeyID,
+	)
+	keyringPath := utils.UpdateStringVar(
+		"KEYRING",
+		flKeyring,
+		configuration.Keyring,
+	)
+
+	hashKeys, err := utils.KeyringKeys(key, keyID, keyringPath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	hashAlg, err := hash.ParseAlgorithm(utils.UpdateStringVar(
+		"HASH_ALGORITHM",
+		flHashAlg,
+		configuration.HashAlgorithm,
+	))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
 	limit := utils.UpdateIntVar(
 		"RATE_LIMIT",
 		flLimit,
@@ -128,8 +202,75 @@ func main() {
 		configuration.Crypto,
 	)
 
+	tlsCA := utils.UpdateStringVar(
+		"TLS_CA",
+		flTLSCA,
+		configuration.TLSCA,
+	)
+	tlsCert := utils.UpdateStringVar(
+		"TLS_CERT",
+		flTLSCert,
+		configuration.TLSCert,
+	)
+	tlsKey := utils.UpdateStringVar(
+		"TLS_KEY",
+		flTLSKey,
+		configuration.TLSKey,
+	)
+
+	token := utils.UpdateStringVar(
+		"TOKEN",
+		flToken,
+		configuration.Token,
+	)
+
+	agentID := utils.UpdateStringVar(
+		"AGENT_ID",
+		flAgentID,
+		configuration.AgentID,
+	)
+
+	localHTTP := utils.UpdateStringVar(
+		"LOCAL_HTTP",
+		flLocalHTTP,
+		configuration.LocalHTTP,
+	)
+	localUDP := utils.UpdateStringVar(
+		"LOCAL_UDP",
+		flLocalUDP,
+		configuration.LocalUDP,
+	)
+
+	telemetryAddr := utils.UpdateStringVar(
+		"TELEMETRY_ADDRESS",
+		flTelemetry,
+		configuration.Telemetry,
+	)
+
+	wpOpts := []clients.WorkerPoolOption{
+		clients.WithHashAlgorithm(hashAlg),
+		clients.WithToken(token),
+		clients.WithAgentID(agentID),
+		clients.WithCollectors(scheduled...),
+		clients.WithLocalIngest(localHTTP, localUDP),
+		clients.WithTelemetryListener(telemetryAddr),
+	}
+	var tlsReloader *tlsconfig.Reloader
+	if tlsCA != "" || tlsCert != "" {
+		tlsReloader, err = tlsconfig.New(tlsCert, tlsKey, tlsCA)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		wpOpts = append(wpOpts, clients.WithTLSConfig(tlsReloader.ClientConfig()))
+	}
+
 	// Creating worker pool
-	wp := clients.NewWorkerPool(limit, address, key, keyPath)
+	wp, err := clients.NewWorkerPool(limit, address, hashKeys, keyPath, wpOpts...)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
 	// Worker pool process start
 	wp.Run()
@@ -137,16 +278,33 @@ func main() {
 	// Agent's process
 	for {
 		select {
-		case <-pollInterval.C:
-			// Updating metrics
-			wp.AddTask("updateMem")
-			wp.AddTask("updateGopsutil")
 		case <-reportInterval.C:
 			// Sending metrics
-			wp.AddTask("upload")
+			wp.AddTask(clients.NewUploadTask(report))
 		case sig := <-signals:
-			wp.Stop()
+			if sig == syscall.SIGHUP {
+				log.Println("Got signal:", sig.String())
+				if tlsReloader != nil {
+					err := tlsReloader.Reload()
+					if err != nil {
+						log.Println("TLS reload:", err)
+					}
+				}
+
+				hashKeys, err := utils.KeyringKeys(key, keyID, keyringPath)
+				if err == nil {
+					err = wp.SetKeys(hashKeys...)
+				}
+				if err != nil {
+					log.Println("Keyring reload:", err)
+				}
+				continue
+			}
+
 			log.Println("Got signal:", sig.String())
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			wp.Stop(ctx)
+			cancel()
 			return
 		}
 	}
@@ -3,14 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/audit"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/cache"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/config"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/crypt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/grpcserver"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ratelimit"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/tlsconfig"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -22,13 +34,16 @@ const (
 	defaultStore     = 300 * time.Second
 	defaultStoreFile = "/tmp/devops-metrics-db.json"
 	defaultRestore   = true
+	defaultMaxBody   = 10 << 20
+	defaultAuditSize = 100 << 20
+	defaultBackups   = 5
 )
 
 type metricRepository interface {
-	GetMetricsMap() map[string]metrics.Metric
-	GetMetric(name string) (metrics.Metric, error)
-	Update(metrics.Metric)
-	BatchUpdate(metrics []metrics.Metric)
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
 }
 
 var (
@@ -40,23 +55,65 @@ var (
 	flStoreFile     *string        // STORE_FILE
 	flRestore       *bool          // RESTORE
 	flKey           *string        // KEY
+	flKeyID         *string        // KEY_ID
+	flKeyring       *string        // KEYRING
+	flSignStrict    *bool          // SIGN_STRICT
 	flDSN           *string        // DATABASE_DSN
 	flCrypt         *string        // CRYPTO_KEY
 	flConfig        *bool          // CONFIG
 	flSubnet        *string        // TRUSTED_SUBNET
+	flDeniedSubnet  *string        // DENIED_SUBNET
+	flProxies       *string        // TRUSTED_PROXIES
+	flGRPCAddr      *string        // GRPC_ADDRESS
+	flTLSCert       *string        // TLS_CERT
+	flTLSKey        *string        // TLS_KEY
+	flTLSClientCA   *string        // TLS_CLIENT_CA
+	flTokens        *string        // TOKENS
+	flMaxBody       *int           // MAX_BODY_SIZE
+	flMaxBatch      *int           // MAX_BATCH
+	flMaxSeries     *int           // MAX_SERIES
+	flRate          *int           // INGEST_RATE
+	flBurst         *int           // INGEST_BURST
+	flGlobalRate    *int           // INGEST_GLOBAL_RATE
+	flGlobalBurst   *int           // INGEST_GLOBAL_BURST
+	flAuditFile     *string        // AUDIT_FILE
+	flAuditMaxSize  *int           // AUDIT_MAX_SIZE
+	flAuditBackups  *int           // AUDIT_BACKUPS
+	flAuditDB       *bool          // AUDIT_DB
 )
 
 func parseFlags() {
 	log.Println("server init...")
-	flAddr = flag.String("a", utils.DefaultAddress, "Server IP address")           // ADDRESS
-	flStoreInterval = flag.Duration("i", defaultStore, "Interval of storing data") // STORE_INTERVAL
-	flStoreFile = flag.String("f", defaultStoreFile, "Path to storage file")       // STORE_FILE
-	flRestore = flag.Bool("r", defaultRestore, "Is need to restore storage")       // RESTORE
-	flKey = flag.String("k", "", "Hash key")                                       // KEY
-	flDSN = flag.String("d", "", "Data source name")                               // DATABASE_DSN
-	flCrypt = flag.String("crypto-key", "", "Path to private crypto key")          // CRYPTO_KEY
-	flConfig = flag.Bool("config", false, "Configuration by config json file")     // CONFIG
-	flSubnet = flag.String("t", "", "Trusted subnet")                              // TRUSTED_SUBNET
+	flAddr = flag.String("a", utils.DefaultAddress, "Server IP address")                          // ADDRESS
+	flStoreInterval = flag.Duration("i", defaultStore, "Interval of storing data")                // STORE_INTERVAL
+	flStoreFile = flag.String("f", defaultStoreFile, "Path to storage file")                      // STORE_FILE
+	flRestore = flag.Bool("r", defaultRestore, "Is need to restore storage")                      // RESTORE
+	flKey = flag.String("k", "", "Hash key")                                                      // KEY
+	flKeyID = flag.String("key-id", "", "ID of hash key")                                         // KEY_ID
+	flKeyring = flag.String("keyring", "", "Path to hash keyring json file")                      // KEYRING
+	flSignStrict = flag.Bool("sign-strict", false, "Reject unsigned update requests")             // SIGN_STRICT
+	flDSN = flag.String("d", "", "Data source name")                                              // DATABASE_DSN
+	flCrypt = flag.String("crypto-key", "", "Path to private crypto key")                         // CRYPTO_KEY
+	flConfig = flag.Bool("config", false, "Configuration by config json file")                    // CONFIG
+	flSubnet = flag.String("t", "", "Comma separated trusted subnets")                            // TRUSTED_SUBNET
+	flDeniedSubnet = flag.String("deny-subnet", "", "Comma separated denied subnets")             // DENIED_SUBNET
+	flProxies = flag.String("trusted-proxies", "", "Comma separated proxy subnets")               // TRUSTED_PROXIES
+	flGRPCAddr = flag.String("g", "", "gRPC server IP address")                                   // GRPC_ADDRESS
+	flTLSCert = flag.String("tls-cert", "", "Path to TLS certificate")                            // TLS_CERT
+	flTLSKey = flag.String("tls-key", "", "Path to TLS private key")                              // TLS_KEY
+	flTLSClientCA = flag.String("tls-client-ca", "", "Path to clients CA bundle")                 // TLS_CLIENT_CA
+	flTokens = flag.String("tokens", "", "Path to API tokens json file")                          // TOKENS
+	flMaxBody = flag.Int("max-body", defaultMaxBody, "Max request body size in bytes")            // MAX_BODY_SIZE
+	flMaxBatch = flag.Int("max-batch", 0, "Max metrics in batch")                                 // MAX_BATCH
+	flMaxSeries = flag.Int("max-series", 0, "Max distinct metrics in storage")                    // MAX_SERIES
+	flRate = flag.Int("rate", 0, "Update requests per second per client")                         // INGEST_RATE
+	flBurst = flag.Int("burst", 0, "Update requests burst per client")                            // INGEST_BURST
+	flGlobalRate = flag.Int("global-rate", 0, "Update requests per second in total")              // INGEST_GLOBAL_RATE
+	flGlobalBurst = flag.Int("global-burst", 0, "Update requests burst in total")                 // INGEST_GLOBAL_BURST
+	flAuditFile = flag.String("audit-file", "", "Path to audit log file")                         // AUDIT_FILE
+	flAuditMaxSize = flag.Int("audit-max-size", defaultAuditSize, "Audit file size to rotate at") // AUDIT_MAX_SIZE
+	flAuditBackups = flag.Int("audit-backups", defaultBackups, "Number of rotated audit files")   // AUDIT_BACKUPS
+	flAuditDB = flag.Bool("audit-db", false, "Write audit log to database")                       // AUDIT_DB
 	flag.Parse()
 }
 
@@ -87,6 +144,26 @@ func main() {
 		flKey,
 		configuration.Key,
 	)
+	keyID := utils.UpdateStringVar(
+		"KEY_ID",
+		flKeyID,
+		configuration.KeyID,
+	)
+	keyringPath := utils.UpdateStringVar(
+		"KEYRING",
+		flKeyring,
+		configuration.Keyring,
+	)
+
+	hashKeys, err := utils.KeyringKeys(key, keyID, keyringPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyring, err := hash.NewKeyring(hashKeys...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	dbDSN := utils.UpdateStringVar(
 		"DATABASE_DSN",
 		flDSN,
@@ -99,12 +176,31 @@ func main() {
 	}
 	defer db.Close()
 
+	if flag.Arg(0) == "token" {
+		err = runToken(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(db, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var storage metricRepository
 	switch dbDSN {
 	case "":
 		storage = repository.NewMemStorage()
 	default:
-		storage = repository.NewPostgreStorage(db)
+		storage, err = repository.NewPostgreStorage(db)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	subnet := utils.UpdateStringVar(
@@ -112,21 +208,146 @@ func main() {
 		flSubnet,
 		configuration.Subnet,
 	)
+	deniedSubnet := utils.UpdateStringVar(
+		"DENIED_SUBNET",
+		flDeniedSubnet,
+		configuration.DeniedSubnet,
+	)
+	filter, err := ipfilter.New(subnet, deniedSubnet)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	router := utils.NewRouter(storage, key, db, subnet)
+	proxies, err := ipfilter.NewResolver(utils.UpdateStringVar(
+		"TRUSTED_PROXIES",
+		flProxies,
+		configuration.Proxies,
+	))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	cryptoPath := utils.UpdateStringVar(
 		"CRYPTO_KEY",
 		flCrypt,
 		configuration.Crypt,
 	)
+
+	routerOpts := []utils.RouterOption{utils.WithClientIPResolver(proxies)}
 	if cryptoPath != "" {
 		c, err := crypt.New(crypt.WithPrivateKey(cryptoPath))
 		if err != nil {
 			log.Fatal(err)
 		}
-		router.Use(c.GetDecryptMiddleware())
+		routerOpts = append(routerOpts, utils.WithDecryption(c))
+	}
+
+	signStrict := utils.UpdateBoolVar(
+		"SIGN_STRICT",
+		flSignStrict,
+		configuration.SignStrict,
+	)
+
+	tokensPath := utils.UpdateStringVar(
+		"TOKENS",
+		flTokens,
+		configuration.Tokens,
+	)
+
+	interceptors := []grpc.UnaryServerInterceptor{filter.UnaryServerInterceptor()}
+	if tokensPath != "" {
+		tokens, err := auth.LoadTokens(tokensPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store, err := auth.NewStore(tokens...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		routerOpts = append(routerOpts, utils.WithTokens(store))
+		interceptors = append(interceptors, auth.UnaryServerInterceptor(store, grpcserver.MethodScopes))
+	}
+
+	rate := utils.UpdateIntVar(
+		"INGEST_RATE",
+		flRate,
+		configuration.Rate,
+	)
+	burst := utils.UpdateIntVar(
+		"INGEST_BURST",
+		flBurst,
+		configuration.Burst,
+	)
+	globalRate := utils.UpdateIntVar(
+		"INGEST_GLOBAL_RATE",
+		flGlobalRate,
+		configuration.GlobalRate,
+	)
+	globalBurst := utils.UpdateIntVar(
+		"INGEST_GLOBAL_BURST",
+		flGlobalBurst,
+		configuration.GlobalBurst,
+	)
+
+	// Zero burst means burst of one second of rate.
+	if burst == 0 {
+		burst = rate
+	}
+	if globalBurst == 0 {
+		globalBurst = globalRate
+	}
+	policy := ratelimit.Policy{
+		PerClient: ratelimit.New(float64(rate), burst),
+		Global:    ratelimit.New(float64(globalRate), globalBurst),
+	}
+	routerOpts = append(routerOpts, utils.WithRateLimit(policy))
+	interceptors = append(interceptors, policy.UnaryServerInterceptor())
+
+	maxBody := utils.UpdateIntVar(
+		"MAX_BODY_SIZE",
+		flMaxBody,
+		configuration.MaxBodySize,
+	)
+	routerOpts = append(routerOpts, utils.WithMaxBodySize(int64(maxBody)))
+
+	// Restoring and exporting data bypass limits, so they apply to ingestion only.
+	ingest := storage
+	limits := repository.Limits{
+		MaxBatch: utils.UpdateIntVar(
+			"MAX_BATCH",
+			flMaxBatch,
+			configuration.MaxBatch,
+		),
+		MaxSeries: utils.UpdateIntVar(
+			"MAX_SERIES",
+			flMaxSeries,
+			configuration.MaxSeries,
+		),
+	}
+	if limits.MaxBatch > 0 || limits.MaxSeries > 0 {
+		ingest = repository.NewLimitedStorage(storage, limits)
+	}
+
+	auditor, err := newAuditor(configuration, db, dbDSN)
+	if err != nil {
+		log.Fatal(err)
 	}
+	if auditor.Enabled() {
+		ingest = audit.NewStorage(ingest, auditor)
+		routerOpts = append(routerOpts, utils.WithAudit(auditor))
+	}
+
+	if keyring.Enabled() {
+		verifier := signature.NewVerifier(keyring, signature.DefaultWindow, signStrict)
+		routerOpts = append(routerOpts, utils.WithSignatureVerifier(verifier))
+		interceptors = append(interceptors, verifier.UnaryServerInterceptor())
+	} else if signStrict {
+		log.Fatal("strict signature mode requires hash key")
+	}
+
+	grpcOpts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}
+
+	router := utils.NewRouter(ingest, keyring, db, filter, routerOpts...)
 
 	address := utils.UpdateStringVar(
 		"ADDRESS",
@@ -135,6 +356,39 @@ func main() {
 	)
 	server := http.Server{Addr: address, Handler: router}
 
+	tlsCert := utils.UpdateStringVar(
+		"TLS_CERT",
+		flTLSCert,
+		configuration.TLSCert,
+	)
+	tlsKey := utils.UpdateStringVar(
+		"TLS_KEY",
+		flTLSKey,
+		configuration.TLSKey,
+	)
+	tlsClientCA := utils.UpdateStringVar(
+		"TLS_CLIENT_CA",
+		flTLSClientCA,
+		configuration.TLSClientCA,
+	)
+
+	var tlsReloader *tlsconfig.Reloader
+	if tlsCert != "" {
+		tlsReloader, err = tlsconfig.New(tlsCert, tlsKey, tlsClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = tlsReloader.ServerConfig()
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsReloader.ServerConfig())))
+	}
+
+	grpcAddress := utils.UpdateStringVar(
+		"GRPC_ADDRESS",
+		flGRPCAddr,
+		configuration.GRPCAddress,
+	)
+	grpcServer := grpcserver.New(ingest, keyring, grpcOpts...)
+
 	cTime := defaultStore
 	if conf {
 		cTime, err = time.ParseDuration(configuration.StoreInterval)
@@ -166,7 +420,7 @@ func main() {
 
 	if restore && dbDSN == "" {
 		log.Println("restoring data from", storeFilePath)
-		err := cache.ImportData(storeFilePath, storage)
+		err := cache.ImportData(context.Background(), storeFilePath, storage)
 		if err != nil {
 			log.Println(err)
 			return
@@ -175,26 +429,69 @@ func main() {
 
 	go func() {
 		log.Println("Listening:", address)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			log.Fatal("HTTP server ListenAndServe:", err)
 		}
 	}()
 
+	if grpcAddress != "" {
+		listener, err := net.Listen("tcp", grpcAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			log.Println("gRPC listening:", grpcAddress)
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal("gRPC server Serve:", err)
+			}
+		}()
+	}
+
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
 
 	for {
 		select {
 		case sig := <-signals:
 			log.Println("Got signal:", sig.String())
 
+			if sig == syscall.SIGHUP {
+				if tlsReloader != nil {
+					err := tlsReloader.Reload()
+					if err != nil {
+						log.Println("TLS reload:", err)
+					}
+				}
+
+				hashKeys, err := utils.KeyringKeys(key, keyID, keyringPath)
+				if err == nil {
+					err = keyring.Set(hashKeys...)
+				}
+				if err != nil {
+					log.Println("Keyring reload:", err)
+				}
+				continue
+			}
+
 			if err := server.Shutdown(context.Background()); err != nil {
 				log.Println("HTTP server Shutdown:", err)
 			}
+			grpcServer.GracefulStop()
+
+			if err := auditor.Close(); err != nil {
+				log.Println("Audit log Close:", err)
+			}
 
 			if dbDSN == "" {
 				log.Println("exporting data after shutdown")
-				err := cache.ExportData(storeFilePath, storage)
+				err := cache.ExportData(context.Background(), storeFilePath, storage)
 				if err != nil {
 					log.Println(err)
 				}
@@ -204,7 +501,7 @@ func main() {
 		case <-storeInterval.C:
 			if dbDSN == "" {
 				log.Println("normal exporting data")
-				err := cache.ExportData(storeFilePath, storage)
+				err := cache.ExportData(context.Background(), storeFilePath, storage)
 				if err != nil {
 					log.Println(err)
 					return
@@ -213,3 +510,52 @@ func main() {
 		}
 	}
 }
+
+// newAuditor - creates auditor with sinks enabled by configuration, it records nothing without sinks.
+func newAuditor(configuration *config.ServerConfig, db *sql.DB, dbDSN string) (*audit.Auditor, error) {
+	var sinks []audit.Sink
+
+	auditFile := utils.UpdateStringVar(
+		"AUDIT_FILE",
+		flAuditFile,
+		configuration.AuditFile,
+	)
+	if auditFile != "" {
+		sink, err := audit.NewFileSink(
+			auditFile,
+			int64(utils.UpdateIntVar(
+				"AUDIT_MAX_SIZE",
+				flAuditMaxSize,
+				configuration.AuditMaxSize,
+			)),
+			utils.UpdateIntVar(
+				"AUDIT_BACKUPS",
+				flAuditBackups,
+				configuration.AuditBackups,
+			),
+		)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	auditDB := utils.UpdateBoolVar(
+		"AUDIT_DB",
+		flAuditDB,
+		configuration.AuditDB,
+	)
+	if auditDB {
+		if dbDSN == "" {
+			return nil, errors.New("database audit log requires database dsn")
+		}
+
+		sink, err := audit.NewPostgresSink(db)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return audit.New(sinks...), nil
+}
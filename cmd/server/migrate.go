@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/migrations"
+	"log"
+	"strconv"
+)
+
+const migrateUsage = "usage: server -d <dsn> migrate up | down [steps] | status"
+
+// runMigrate - handles "migrate" subcommand: applying, reverting and printing status of schema migrations.
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Println("applied migrations:", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid steps %q: %s", args[1], migrateUsage)
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Println("reverted migrations:", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
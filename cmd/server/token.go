@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"strings"
+)
+
+const tokenUsage = "usage: server token -name <name> -scopes ingest,read,admin"
+
+// runToken - handles "token" subcommand: generates API token and prints it with its token store entry.
+func runToken(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	name := fs.String("name", "", "Token name")
+	scopes := fs.String("scopes", "", "Comma separated token scopes")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *name == "" || *scopes == "" {
+		return errors.New(tokenUsage)
+	}
+
+	var tokenScopes []auth.Scope
+	for _, scope := range strings.Split(*scopes, ",") {
+		tokenScopes = append(tokenScopes, auth.Scope(strings.TrimSpace(scope)))
+	}
+
+	token, entry, err := auth.Generate(*name, tokenScopes...)
+	if err != nil {
+		return err
+	}
+
+	_, err = auth.NewStore(entry)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("token:", token)
+	fmt.Println(string(data))
+	return nil
+}
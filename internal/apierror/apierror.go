@@ -0,0 +1,92 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+)
+
+// Codes of structured error responses.
+const (
+	CodeInvalidRequest     = wire.CodeInvalidRequest
+	CodeInvalidMetric      = wire.CodeInvalidMetric
+	CodeUnsupportedType    = wire.CodeUnsupportedType
+	CodeHashMismatch       = wire.CodeHashMismatch
+	CodeInvalidSignature   = wire.CodeInvalidSignature
+	CodeNotFound           = wire.CodeNotFound
+	CodeUnauthorized       = wire.CodeUnauthorized
+	CodeForbidden          = wire.CodeForbidden
+	CodeEncryptionRequired = wire.CodeEncryptionRequired
+	CodeDecryptionFailed   = wire.CodeDecryptionFailed
+	CodePayloadTooLarge    = wire.CodePayloadTooLarge
+	CodeSeriesLimit        = wire.CodeSeriesLimit
+	CodeRateLimited        = wire.CodeRateLimited
+	CodeStorageUnavailable = wire.CodeStorageUnavailable
+	CodeInternal           = wire.CodeInternal
+)
+
+// Response - error envelope, lets clients tell one failure from another and find it in server logs.
+type Response = wire.ErrorResponse
+
+type requestIDKey struct{}
+
+// WithRequestID - returns ctx whose error responses carry request id. Package doesn't read id of router itself,
+// so clients which share its codes don't depend on router.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Write - writes status code and json error envelope, request id is taken from WithRequestID.
+func Write(rw http.ResponseWriter, r *http.Request, statusCode int, code, message string) {
+	body, err := json.Marshal(Response{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(r.Context()),
+	})
+	if err != nil {
+		log.Println(err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	_, err = rw.Write(body)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// WriteBodyError - writes error of reading request body: 413 if body is over limit of http.MaxBytesReader.
+func WriteBodyError(rw http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Write(rw, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+
+	log.Println(err)
+	Write(rw, r, http.StatusInternalServerError, CodeInternal, "couldn't read request body")
+}
+
+// Status - creates gRPC status carrying error code in errdetails.ErrorInfo, so clients don't parse its message.
+func Status(c codes.Code, code, message string) error {
+	s, err := status.New(c, message).WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: wire.ErrorDomain})
+	if err != nil {
+		log.Println(err)
+		return status.Error(c, message)
+	}
+	return s.Err()
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
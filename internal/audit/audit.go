@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"log"
+	"time"
+)
+
+// AgentIDHeader - header and gRPC metadata key with ID that agent declares about itself.
+// Unlike token name, it isn't authenticated.
+const AgentIDHeader = wire.AgentIDHeader
+
+// Action - kind of audited action.
+type Action string
+
+const (
+	ActionUpdate Action = "update"
+	ActionAdmin  Action = "admin"
+)
+
+var ErrNotQueryable = errors.New("audit log can't be queried")
+
+// Event - audit record. Update events list names of changed metrics,
+// admin events contain method, path and status of request.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Action    Action    `json:"action"`
+	Source    string    `json:"source,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	AgentID   string    `json:"agent_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	Names     []string  `json:"names,omitempty"`
+}
+
+// Sink - destination of audit records.
+type Sink interface {
+	Write(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Querier - sink that can return records of time range [from, to) in order of writing.
+// If range has more than limit records, only the latest ones are returned. Limit less than 1 means no limit.
+type Querier interface {
+	Query(ctx context.Context, from, to time.Time, limit int) ([]Event, error)
+}
+
+// Auditor - writes audit records to every sink. Nil auditor or auditor without sinks records nothing.
+type Auditor struct {
+	sinks []Sink
+	now   func() time.Time
+}
+
+// New - creates auditor writing to sinks.
+func New(sinks ...Sink) *Auditor {
+	return &Auditor{sinks: sinks, now: time.Now}
+}
+
+// Enabled - reports whether auditor has any sinks.
+func (a *Auditor) Enabled() bool {
+	return a != nil && len(a.sinks) > 0
+}
+
+// Record - stamps event with current time and writes it to every sink.
+// Failed sinks are logged, audited action isn't undone.
+func (a *Auditor) Record(ctx context.Context, e Event) {
+	if !a.Enabled() {
+		return
+	}
+
+	e.Time = a.now().UTC()
+	for _, sink := range a.sinks {
+		err := sink.Write(ctx, e)
+		if err != nil {
+			log.Println("audit:", err)
+		}
+	}
+}
+
+// Query - returns at most limit latest records of time range [from, to) from the first sink that can be queried.
+func (a *Auditor) Query(ctx context.Context, from, to time.Time, limit int) ([]Event, error) {
+	if a != nil {
+		for _, sink := range a.sinks {
+			if q, ok := sink.(Querier); ok {
+				return q.Query(ctx, from, to, limit)
+			}
+		}
+	}
+
+	return nil, ErrNotQueryable
+}
+
+// latest - keeps the latest limit events added in order of writing, limit less than 1 keeps every event.
+type latest struct {
+	events []Event
+	next   int
+	limit  int
+}
+
+func newLatest(limit int) *latest {
+	return &latest{events: []Event{}, limit: limit}
+}
+
+func (l *latest) add(e Event) {
+	if l.limit < 1 || len(l.events) < l.limit {
+		l.events = append(l.events, e)
+		return
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % l.limit
+}
+
+// list - returns events from the oldest one.
+func (l *latest) list() []Event {
+	return append(l.events[l.next:], l.events[:l.next]...)
+}
+
+// Close - closes every sink, returns the first error.
+func (a *Auditor) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	var result error
+	for _, sink := range a.sinks {
+		err := sink.Close()
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
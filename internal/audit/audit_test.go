@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newFileAuditor(t *testing.T, maxSize int64, backups int) (*Auditor, *FileSink, *time.Time) {
+	sink, err := NewFileSink(filepath.Join(t.TempDir(), "audit.log"), maxSize, backups)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+
+	now := start
+	a := New(sink)
+	a.now = func() time.Time { return now }
+	return a, sink, &now
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+	a, sink, now := newFileAuditor(t, 200, 2)
+
+	for i := 0; i < 10; i++ {
+		a.Record(context.Background(), Event{Action: ActionUpdate, Count: i, Names: []string{"Alloc", "PollCount"}})
+		*now = now.Add(time.Minute)
+	}
+
+	_, err := os.Stat(sink.backup(2))
+	require.NoError(t, err, "second backup must exist")
+	_, err = os.Stat(sink.backup(3))
+	assert.True(t, os.IsNotExist(err), "backups beyond limit must be removed")
+
+	info, err := os.Stat(sink.path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(200))
+
+	events, err := a.Query(context.Background(), start, start.Add(time.Hour), 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Equal(t, 9, events[len(events)-1].Count, "the newest record must be the last one")
+	for i := 1; i < len(events); i++ {
+		assert.Equal(t, events[i-1].Count+1, events[i].Count, "records must keep order of writing")
+	}
+}
+
+func TestFileSink_Query(t *testing.T) {
+	a, _, now := newFileAuditor(t, 0, 0)
+
+	for i := 0; i < 5; i++ {
+		a.Record(context.Background(), Event{Action: ActionAdmin, Status: http.StatusOK, Count: i})
+		*now = now.Add(time.Minute)
+	}
+
+	tests := []struct {
+		name  string
+		from  time.Time
+		to    time.Time
+		limit int
+		want  []int
+	}{
+		{name: "Whole range", from: start, to: start.Add(time.Hour), want: []int{0, 1, 2, 3, 4}},
+		{name: "From is inclusive, to is exclusive", from: start.Add(time.Minute), to: start.Add(3 * time.Minute), want: []int{1, 2}},
+		{name: "Empty range", from: start.Add(time.Hour), to: start.Add(2 * time.Hour), want: []int{}},
+		{name: "Limit keeps the latest records", from: start, to: start.Add(time.Hour), limit: 2, want: []int{3, 4}},
+		{name: "Limit over range", from: start, to: start.Add(2 * time.Minute), limit: 3, want: []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := a.Query(context.Background(), tt.from, tt.to, tt.limit)
+			require.NoError(t, err)
+
+			got := []int{}
+			for _, e := range events {
+				got = append(got, e.Count)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStorage(t *testing.T) {
+	a, _, _ := newFileAuditor(t, 0, 0)
+	storage := NewStorage(repository.NewMemStorage(), a)
+
+	handler := Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		err := storage.BatchUpdate(r.Context(), []metrics.Metric{
+			metrics.NewMetricGauge("b", 1),
+			metrics.NewMetricCounter("a", 1),
+			metrics.NewMetricCounter("a", 2),
+		})
+		require.NoError(t, err)
+	}))
+
+	request := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	request.Header.Set(AgentIDHeader, "host-1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	events, err := a.Query(context.Background(), start, start.Add(time.Hour), 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	e := events[0]
+	assert.Equal(t, ActionUpdate, e.Action)
+	assert.Equal(t, start, e.Time)
+	assert.Equal(t, 3, e.Count)
+	assert.Equal(t, []string{"a", "b"}, e.Names)
+	assert.Equal(t, "host-1", e.AgentID)
+	assert.Equal(t, http.MethodPost, e.Method)
+	assert.Equal(t, "/updates/", e.Path)
+	assert.Equal(t, "192.0.2.1", e.Source)
+}
+
+func TestHandler(t *testing.T) {
+	a, _, now := newFileAuditor(t, 0, 0)
+	*now = time.Now().Add(-time.Hour)
+	admin := a.Admin(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+
+	tests := []struct {
+		name       string
+		auditor    *Auditor
+		query      string
+		statusCode int
+		events     int
+	}{
+		{name: "Default range", auditor: a, statusCode: http.StatusOK, events: 1},
+		{name: "Range without events", auditor: a, query: "?to=2000-01-01T00:00:00Z", statusCode: http.StatusOK, events: 0},
+		{name: "Invalid time", auditor: a, query: "?from=yesterday", statusCode: http.StatusBadRequest},
+		{name: "Reversed range", auditor: a, query: "?from=2001-01-01T00:00:00Z&to=2000-01-01T00:00:00Z", statusCode: http.StatusBadRequest},
+		{name: "Limit", auditor: a, query: "?limit=1", statusCode: http.StatusOK, events: 1},
+		{name: "Invalid limit", auditor: a, query: "?limit=0", statusCode: http.StatusBadRequest},
+		{name: "Disabled audit", auditor: nil, statusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			Handler(tt.auditor).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/audit"+tt.query, nil))
+			require.Equal(t, tt.statusCode, recorder.Code)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+
+			var events []Event
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&events))
+			require.Len(t, events, tt.events)
+			if tt.events > 0 {
+				assert.Equal(t, ActionAdmin, events[0].Action)
+				assert.Equal(t, http.StatusTeapot, events[0].Status)
+				assert.Equal(t, "/debug/pprof/", events[0].Path)
+			}
+		})
+	}
+}
+
+// Requires running postgres: TEST_DATABASE_DSN="postgres://..." go test ./internal/audit
+func TestPostgresSink(t *testing.T) {
+	dsn, ok := os.LookupEnv("TEST_DATABASE_DSN")
+	if !ok {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	sink, err := NewPostgresSink(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`DELETE FROM audit_log WHERE at >= $1 AND at < $2`, start, start.Add(time.Hour))
+	require.NoError(t, err)
+
+	written := Event{Time: start, Action: ActionUpdate, Source: "10.0.0.1", Token: "agent", Count: 2, Names: []string{"a", "b"}}
+	require.NoError(t, sink.Write(context.Background(), written))
+	// Agent ID is declared by client, its length mustn't make event lost
+	long := Event{Time: start.Add(time.Second), Action: ActionUpdate, AgentID: strings.Repeat("a", 1000)}
+	require.NoError(t, sink.Write(context.Background(), long))
+
+	events, err := sink.Query(context.Background(), start, start.Add(time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{written, long}, events)
+}
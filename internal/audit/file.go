@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSink - writes records as json lines. File is rotated when it grows over maxSize:
+// it is renamed to "<path>.1", previous backups are shifted and the oldest beyond backups is removed.
+type FileSink struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// NewFileSink - opens file sink, records are appended to existing file.
+func NewFileSink(path string, maxSize int64, backups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxSize: maxSize, backups: backups}
+
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) backup(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
+
+// rotate - file is reopened even if renaming failed, so sink keeps writing.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = s.shift()
+	openErr := s.open()
+	if err != nil {
+		return err
+	}
+	return openErr
+}
+
+func (s *FileSink) shift() error {
+	if s.backups == 0 {
+		return os.Remove(s.path)
+	}
+
+	for n := s.backups - 1; n > 0; n-- {
+		err := os.Rename(s.backup(n), s.backup(n+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.backup(1))
+}
+
+func (s *FileSink) Write(_ context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		err = s.rotate()
+		if err != nil {
+			return fmt.Errorf("rotating %s: %w", s.path, err)
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Query - reads backups from the oldest one and then current file. Files are opened under lock and read
+// without it, so queries don't block writes. Records written after files were opened aren't returned.
+func (s *FileSink) Query(ctx context.Context, from, to time.Time, limit int) ([]Event, error) {
+	files, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+
+	events := newLatest(limit)
+	for _, f := range files {
+		err = readEvents(ctx, f.file.Name(), f.reader, func(e Event) {
+			if !e.Time.Before(from) && e.Time.Before(to) {
+				events.add(e)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return events.list(), nil
+}
+
+type openedFile struct {
+	file   *os.File
+	reader io.Reader
+}
+
+// snapshot - opens backups and current file, which is read only up to its current size, as it is still written.
+// Opened files are read even if they are rotated meanwhile.
+func (s *FileSink) snapshot() ([]openedFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make([]openedFile, 0, s.backups+1)
+	for n := s.backups; n >= 0; n-- {
+		path := s.path
+		if n > 0 {
+			path = s.backup(n)
+		}
+
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.file.Close()
+			}
+			return nil, err
+		}
+
+		var reader io.Reader = file
+		if n == 0 {
+			reader = io.LimitReader(file, s.size)
+		}
+		files = append(files, openedFile{file: file, reader: reader})
+	}
+
+	return files, nil
+}
+
+func readEvents(ctx context.Context, name string, r io.Reader, fn func(e Event)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var e Event
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fn(e)
+	}
+
+	return scanner.Err()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
+	"github.com/go-chi/chi/v5/middleware"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRange - range of query without "from" parameter.
+	defaultRange = 24 * time.Hour
+	// defaultLimit - number of records returned without "limit" parameter.
+	defaultLimit = 1000
+	// maxLimit - max number of records returned by one query, larger limit is lowered to it.
+	maxLimit = 10000
+)
+
+type requestKey struct{}
+
+type request struct {
+	source  string
+	method  string
+	path    string
+	agentID string
+}
+
+// Middleware - keeps client ip, method, path and declared agent ID of request in its context for Storage.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			info := request{
+				source:  ipfilter.ClientIP(r).String(),
+				method:  r.Method,
+				path:    r.URL.Path,
+				agentID: r.Header.Get(AgentIDHeader),
+			}
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requestKey{}, info)))
+		},
+	)
+}
+
+// Admin - records every request to admin endpoint with its response status.
+func (a *Auditor) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			if !a.Enabled() {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(rw, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			e := Event{
+				Action:    ActionAdmin,
+				Source:    ipfilter.ClientIP(r).String(),
+				RequestID: middleware.GetReqID(r.Context()),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    ww.Status(),
+				AgentID:   r.Header.Get(AgentIDHeader),
+			}
+			if token, ok := auth.FromContext(r.Context()); ok {
+				e.Token = token.Name
+			}
+			a.Record(r.Context(), e)
+		},
+	)
+}
+
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseLimit - parses "limit" parameter, it is lowered to maxLimit.
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 {
+		return 0, errors.New("must be positive")
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+// Handler - returns the latest "limit" records of range ["from", "to"), both RFC 3339. Range defaults to the last day.
+// Earlier records are paged by passing time of the first returned record as "to".
+func Handler(a *Auditor) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		limit, err := parseLimit(r.URL.Query().Get("limit"))
+		if err != nil {
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "limit: "+err.Error())
+			return
+		}
+
+		to, err := parseTime(r.URL.Query().Get("to"), time.Now())
+		if err != nil {
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "to: "+err.Error())
+			return
+		}
+
+		from, err := parseTime(r.URL.Query().Get("from"), to.Add(-defaultRange))
+		if err != nil {
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "from: "+err.Error())
+			return
+		}
+
+		if !from.Before(to) {
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "from must be earlier than to")
+			return
+		}
+
+		events, err := a.Query(r.Context(), from, to, limit)
+		if errors.Is(err, ErrNotQueryable) {
+			apierror.Write(rw, r, http.StatusNotFound, apierror.CodeNotFound, "audit log is disabled")
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeStorageUnavailable, "couldn't read audit log")
+			return
+		}
+
+		body, err := json.Marshal(events)
+		if err != nil {
+			log.Println(err)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeInternal, "couldn't marshal audit log")
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_, err = rw.Write(body)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/migrations"
+	"github.com/lib/pq"
+	"time"
+)
+
+// PostgresSink - writes records to audit_log table.
+type PostgresSink struct {
+	db *sql.DB
+}
+
+// NewPostgresSink - creates sink and applies pending schema migrations.
+func NewPostgresSink(db *sql.DB) (*PostgresSink, error) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgresSink{db: db}, nil
+}
+
+func (s *PostgresSink) Write(ctx context.Context, e Event) error {
+	names := e.Names
+	if names == nil {
+		names = []string{}
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO audit_log (at, action, source, token, agent_id, request_id, method, path, status, count, names)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		e.Time, string(e.Action), e.Source, e.Token, e.AgentID, e.RequestID, e.Method, e.Path, e.Status, e.Count, pq.Array(names),
+	)
+	return err
+}
+
+// Query - selects the latest records of range, which are returned in order of writing.
+func (s *PostgresSink) Query(ctx context.Context, from, to time.Time, limit int) ([]Event, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT at, action, source, token, agent_id, request_id, method, path, status, count, names
+FROM audit_log WHERE at >= $1 AND at < $2 ORDER BY at DESC, id DESC LIMIT $3`,
+		from, to, sql.NullInt64{Int64: int64(limit), Valid: limit > 0},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var (
+			e      Event
+			action string
+			names  []string
+		)
+		err = rows.Scan(&e.Time, &action, &e.Source, &e.Token, &e.AgentID, &e.RequestID, &e.Method, &e.Path, &e.Status, &e.Count, pq.Array(&names))
+		if err != nil {
+			return nil, err
+		}
+
+		e.Time = e.Time.UTC()
+		e.Action = Action(action)
+		if len(names) > 0 {
+			e.Names = names
+		}
+		events = append(events, e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// Close - does nothing, database is closed by its owner.
+func (s *PostgresSink) Close() error {
+	return nil
+}
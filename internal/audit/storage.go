@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"sort"
+)
+
+type metricRepository interface {
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
+}
+
+// Storage - storage decorator that records every accepted update with identity of its sender.
+type Storage struct {
+	metricRepository
+	auditor *Auditor
+}
+
+// NewStorage - wraps storage with auditing.
+func NewStorage(storage metricRepository, auditor *Auditor) *Storage {
+	return &Storage{metricRepository: storage, auditor: auditor}
+}
+
+func (s *Storage) Update(ctx context.Context, metric metrics.Metric) error {
+	err := s.metricRepository.Update(ctx, metric)
+	if err != nil {
+		return err
+	}
+
+	s.auditor.Record(ctx, updateEvent(ctx, []metrics.Metric{metric}))
+	return nil
+}
+
+func (s *Storage) BatchUpdate(ctx context.Context, mtrcs []metrics.Metric) error {
+	err := s.metricRepository.BatchUpdate(ctx, mtrcs)
+	if err != nil {
+		return err
+	}
+
+	s.auditor.Record(ctx, updateEvent(ctx, mtrcs))
+	return nil
+}
+
+// updateEvent - describes batch and its sender, taken from http request or gRPC call of ctx.
+func updateEvent(ctx context.Context, mtrcs []metrics.Metric) Event {
+	e := Event{Action: ActionUpdate, Count: len(mtrcs), RequestID: middleware.GetReqID(ctx)}
+
+	unique := make(map[string]struct{}, len(mtrcs))
+	for _, metric := range mtrcs {
+		if _, ok := unique[metric.GetName()]; !ok {
+			unique[metric.GetName()] = struct{}{}
+			e.Names = append(e.Names, metric.GetName())
+		}
+	}
+	sort.Strings(e.Names)
+
+	if token, ok := auth.FromContext(ctx); ok {
+		e.Token = token.Name
+	}
+
+	if info, ok := ctx.Value(requestKey{}).(request); ok {
+		e.Source, e.Method, e.Path, e.AgentID = info.source, info.method, info.path, info.agentID
+		return e
+	}
+
+	if method, ok := grpc.Method(ctx); ok {
+		e.Method = "gRPC"
+		e.Path = method
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(AgentIDHeader)) > 0 {
+		e.AgentID = md.Get(AgentIDHeader)[0]
+	}
+	if ip := ipfilter.PeerIP(ctx); ip != nil {
+		e.Source = ip.String()
+	}
+
+	return e
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scope - permission granted to token.
+type Scope string
+
+const (
+	// ScopeIngest - sending metrics.
+	ScopeIngest Scope = "ingest"
+	// ScopeRead - reading metrics.
+	ScopeRead Scope = "read"
+	// ScopeAdmin - debug endpoints, grants every other scope as well.
+	ScopeAdmin Scope = "admin"
+
+	tokenSize    = 32
+	bearerPrefix = "Bearer "
+)
+
+// Token - entry of token store. Only SHA-256 of token is stored, so leaked config doesn't leak tokens.
+type Token struct {
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// Allows - reports whether token grants scope.
+func (t Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// HashToken - returns hex SHA-256 of token as it is kept in token store.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate - creates random token and its store entry.
+func Generate(name string, scopes ...Scope) (string, Token, error) {
+	data := make([]byte, tokenSize)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	token := hex.EncodeToString(data)
+	return token, Token{Name: name, Hash: HashToken(token), Scopes: scopes}, nil
+}
+
+// Store - tokens known to server. Nil or empty store disables authentication.
+type Store struct {
+	tokens map[string]Token
+}
+
+// NewStore - creates store, token hashes must be unique hex SHA-256 and scopes must be known.
+func NewStore(tokens ...Token) (*Store, error) {
+	s := &Store{tokens: make(map[string]Token, len(tokens))}
+
+	for _, token := range tokens {
+		hash := strings.ToLower(token.Hash)
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("token %q: hash must be hex SHA-256", token.Name)
+		}
+
+		if len(token.Scopes) == 0 {
+			return nil, fmt.Errorf("token %q: no scopes", token.Name)
+		}
+		for _, scope := range token.Scopes {
+			switch scope {
+			case ScopeIngest, ScopeRead, ScopeAdmin:
+			default:
+				return nil, fmt.Errorf("token %q: unknown scope %q", token.Name, scope)
+			}
+		}
+
+		if _, ok := s.tokens[hash]; ok {
+			return nil, fmt.Errorf("token %q: duplicate hash", token.Name)
+		}
+		s.tokens[hash] = token
+	}
+
+	return s, nil
+}
+
+// LoadTokens - reads tokens from JSON file with array of tokens.
+func LoadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []Token
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("tokens %s: %w", path, err)
+	}
+
+	return tokens, nil
+}
+
+// Enabled - reports whether store has any tokens.
+func (s *Store) Enabled() bool {
+	return s != nil && len(s.tokens) > 0
+}
+
+// Authenticate - returns store entry of token.
+func (s *Store) Authenticate(token string) (Token, bool) {
+	if !s.Enabled() || token == "" {
+		return Token{}, false
+	}
+
+	t, ok := s.tokens[HashToken(token)]
+	return t, ok
+}
+
+type tokenKey struct{}
+
+// FromContext - returns token that authenticated request of ctx.
+func FromContext(ctx context.Context) (Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(Token)
+	return t, ok
+}
+
+func withToken(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// Require - returns middleware that lets through only requests with bearer token granting scope.
+// Every request passes if store is disabled.
+func Require(s *Store, scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(rw http.ResponseWriter, r *http.Request) {
+				if !s.Enabled() {
+					next.ServeHTTP(rw, r)
+					return
+				}
+
+				header := r.Header.Get("Authorization")
+				if !strings.HasPrefix(header, bearerPrefix) {
+					rw.Header().Set("WWW-Authenticate", "Bearer")
+					apierror.Write(rw, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "bearer token required")
+					return
+				}
+
+				token, ok := s.Authenticate(strings.TrimPrefix(header, bearerPrefix))
+				if !ok {
+					rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					apierror.Write(rw, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid token")
+					return
+				}
+
+				if !token.Allows(scope) {
+					apierror.Write(rw, r, http.StatusForbidden, apierror.CodeForbidden, fmt.Sprintf("token has no %q scope", scope))
+					return
+				}
+
+				next.ServeHTTP(rw, r.WithContext(withToken(r.Context(), token)))
+			},
+		)
+	}
+}
+
+// RequireAdmin - returns middleware that lets through only requests with admin token. Unlike Require,
+// it denies every request if store is disabled, so admin endpoints aren't public by default.
+func RequireAdmin(s *Store) func(http.Handler) http.Handler {
+	require := Require(s, ScopeAdmin)
+	return func(next http.Handler) http.Handler {
+		if s.Enabled() {
+			return require(next)
+		}
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			apierror.Write(rw, r, http.StatusForbidden, apierror.CodeForbidden, "admin endpoints are disabled without tokens")
+		})
+	}
+}
+
+// bearerTransport - round tripper that adds bearer token to requests.
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+// BearerTransport - returns round tripper that sends token with every request through base,
+// http.DefaultTransport is used if base is nil.
+func BearerTransport(token string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return bearerTransport{token: token, base: base}
+}
+
+func (t bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", bearerPrefix+t.token)
+	return t.base.RoundTrip(r)
+}
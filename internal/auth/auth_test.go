@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStore(t *testing.T) (*Store, map[Scope]string) {
+	secrets := make(map[Scope]string)
+	var tokens []Token
+	for _, scope := range []Scope{ScopeIngest, ScopeRead, ScopeAdmin} {
+		secret, token, err := Generate(string(scope), scope)
+		require.NoError(t, err)
+		secrets[scope] = secret
+		tokens = append(tokens, token)
+	}
+
+	s, err := NewStore(tokens...)
+	require.NoError(t, err)
+	return s, secrets
+}
+
+func TestNewStore(t *testing.T) {
+	valid := HashToken("secret")
+
+	tests := []struct {
+		name    string
+		tokens  []Token
+		wantErr bool
+	}{
+		{
+			name:   "Valid tokens",
+			tokens: []Token{{Name: "agent", Hash: valid, Scopes: []Scope{ScopeIngest}}},
+		},
+		{
+			name:   "Upper case hash",
+			tokens: []Token{{Name: "agent", Hash: strings.ToUpper(valid), Scopes: []Scope{ScopeIngest}}},
+		},
+		{
+			name:    "Plain token instead of hash",
+			tokens:  []Token{{Name: "agent", Hash: "secret", Scopes: []Scope{ScopeIngest}}},
+			wantErr: true,
+		},
+		{
+			name:    "No scopes",
+			tokens:  []Token{{Name: "agent", Hash: valid}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown scope",
+			tokens:  []Token{{Name: "agent", Hash: valid, Scopes: []Scope{"write"}}},
+			wantErr: true,
+		},
+		{
+			name: "Duplicate hash",
+			tokens: []Token{
+				{Name: "agent", Hash: valid, Scopes: []Scope{ScopeIngest}},
+				{Name: "dashboard", Hash: valid, Scopes: []Scope{ScopeRead}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStore(tt.tokens...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			token, ok := s.Authenticate("secret")
+			require.True(t, ok)
+			assert.Equal(t, "agent", token.Name)
+		})
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	data := `[{"name": "agent", "hash": "` + HashToken("secret") + `", "scopes": ["ingest", "read"]}]`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+
+	tokens, err := LoadTokens(path)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, []Scope{ScopeIngest, ScopeRead}, tokens[0].Scopes)
+
+	s, err := NewStore(tokens...)
+	require.NoError(t, err)
+	assert.True(t, s.Enabled())
+}
+
+func TestRequire(t *testing.T) {
+	s, secrets := newStore(t)
+
+	tests := []struct {
+		name       string
+		store      *Store
+		scope      Scope
+		header     string
+		statusCode int
+	}{
+		{
+			name:       "Disabled store",
+			store:      nil,
+			scope:      ScopeAdmin,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Missing token",
+			store:      s,
+			scope:      ScopeRead,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Unknown token",
+			store:      s,
+			scope:      ScopeRead,
+			header:     "Bearer unknown",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Basic credentials",
+			store:      s,
+			scope:      ScopeRead,
+			header:     "Basic " + secrets[ScopeRead],
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Matching scope",
+			store:      s,
+			scope:      ScopeRead,
+			header:     "Bearer " + secrets[ScopeRead],
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Missing scope",
+			store:      s,
+			scope:      ScopeIngest,
+			header:     "Bearer " + secrets[ScopeRead],
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Admin grants every scope",
+			store:      s,
+			scope:      ScopeIngest,
+			header:     "Bearer " + secrets[ScopeAdmin],
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Require(tt.store, tt.scope)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				_, ok := FromContext(r.Context())
+				assert.Equal(t, tt.store.Enabled(), ok)
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+			assert.Equal(t, tt.statusCode, recorder.Code)
+			if tt.statusCode == http.StatusUnauthorized {
+				assert.NotEmpty(t, recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	s, secrets := newStore(t)
+
+	tests := []struct {
+		name       string
+		store      *Store
+		header     string
+		statusCode int
+	}{
+		{
+			name:       "Disabled store",
+			store:      nil,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Missing token",
+			store:      s,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Read token",
+			store:      s,
+			header:     "Bearer " + secrets[ScopeRead],
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Admin token",
+			store:      s,
+			header:     "Bearer " + secrets[ScopeAdmin],
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAdmin(tt.store)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+			assert.Equal(t, tt.statusCode, recorder.Code)
+		})
+	}
+}
+
+func TestBearerTransport(t *testing.T) {
+	s, secrets := newStore(t)
+	server := httptest.NewServer(Require(s, ScopeIngest)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	defer server.Close()
+
+	client := &http.Client{Transport: BearerTransport(secrets[ScopeIngest], nil)}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+type metricsServer struct {
+	proto.UnimplementedMetricsCollectionServer
+}
+
+func (metricsServer) UpdateMetrics(context.Context, *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error) {
+	return &proto.BatchUpdateMetricsResponse{}, nil
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	s, secrets := newStore(t)
+	scopes := map[string]Scope{proto.MetricsCollection_UpdateMetrics_FullMethodName: ScopeIngest}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor(s, scopes)))
+	proto.RegisterMetricsCollectionServer(server, metricsServer{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name  string
+		token string
+		code  codes.Code
+	}{
+		{name: "Missing token", code: codes.Unauthenticated},
+		{name: "Unknown token", token: "unknown", code: codes.Unauthenticated},
+		{name: "Missing scope", token: secrets[ScopeRead], code: codes.PermissionDenied},
+		{name: "Matching scope", token: secrets[ScopeIngest], code: codes.OK},
+		{name: "Admin token", token: secrets[ScopeAdmin], code: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+			if tt.token != "" {
+				opts = append(opts, grpc.WithPerRPCCredentials(BearerCredentials(tt.token, false)))
+			}
+
+			conn, err := grpc.Dial(listener.Addr().String(), opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = proto.NewMetricsCollectionClient(conn).UpdateMetrics(ctx, &proto.BatchUpdateMetricsRequest{})
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// UnaryServerInterceptor - lets through only calls with bearer token in "authorization" metadata
+// that grants scope required by method. Methods missing in scopes require admin scope.
+func UnaryServerInterceptor(s *Store, scopes map[string]Scope) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !s.Enabled() {
+			return handler(ctx, req)
+		}
+
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			header = md.Get("authorization")[0]
+		}
+
+		if !strings.HasPrefix(header, bearerPrefix) {
+			return nil, status.Error(codes.Unauthenticated, "bearer token required")
+		}
+
+		token, ok := s.Authenticate(strings.TrimPrefix(header, bearerPrefix))
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		scope, ok := scopes[info.FullMethod]
+		if !ok {
+			scope = ScopeAdmin
+		}
+
+		if !token.Allows(scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "token has no %q scope", scope)
+		}
+
+		return handler(withToken(ctx, token), req)
+	}
+}
+
+// bearerCredentials - per-call credentials that send bearer token.
+type bearerCredentials struct {
+	token  string
+	secure bool
+}
+
+// BearerCredentials - returns per-call credentials for grpc.WithPerRPCCredentials.
+// If secure is set, token is sent only over TLS connections.
+func BearerCredentials(token string, secure bool) credentials.PerRPCCredentials {
+	return bearerCredentials{token: token, secure: secure}
+}
+
+func (c bearerCredentials) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": bearerPrefix + c.token}, nil
+}
+
+func (c bearerCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/handlers"
@@ -10,8 +11,8 @@ import (
 )
 
 type metricRepository interface {
-	GetMetricsMap() map[string]metrics.Metric
-	Update(metrics.Metric)
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
 }
 
 type importer struct {
@@ -31,7 +32,7 @@ func newImporter(filename string) (*importer, error) {
 	}, nil
 }
 
-func (imp *importer) importStorage(storage metricRepository) error {
+func (imp *importer) importStorage(ctx context.Context, storage metricRepository) error {
 	for {
 		var jsonMetric handlers.JSONMetric
 		err := imp.decoder.Decode(&jsonMetric)
@@ -42,31 +43,28 @@ func (imp *importer) importStorage(storage metricRepository) error {
 			return err
 		}
 
-		var metric metrics.Metric
-
-		switch jsonMetric.MType {
-		case "gauge":
-			metric = metrics.NewMetricGauge(jsonMetric.ID, metrics.Gauge(*jsonMetric.Value))
-		case "counter":
-			metric = metrics.NewMetricCounter(jsonMetric.ID, metrics.Counter(*jsonMetric.Delta))
-		default:
-			return errors.New("not implemented type")
+		metric, err := jsonMetric.ToMetric()
+		if err != nil {
+			return err
 		}
 
-		storage.Update(metric)
+		err = storage.Update(ctx, metric)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func ImportData(filename string, storage metricRepository) error {
+func ImportData(ctx context.Context, filename string, storage metricRepository) error {
 	imp, err := newImporter(filename)
 	if err != nil {
 		return err
 	}
 	defer imp.close()
 
-	err = imp.importStorage(storage)
+	err = imp.importStorage(ctx, storage)
 	return err
 }
 
@@ -91,8 +89,11 @@ func newExporter(filename string) (*exporter, error) {
 	}, nil
 }
 
-func (exp *exporter) exportStorage(storage metricRepository) error {
-	metricMap := storage.GetMetricsMap()
+func (exp *exporter) exportStorage(ctx context.Context, storage metricRepository) error {
+	metricMap, err := storage.GetMetricsMap(ctx)
+	if err != nil {
+		return err
+	}
 
 	for _, value := range metricMap {
 		err := exp.exportEvent(value)
@@ -118,13 +119,13 @@ func (exp *exporter) close() error {
 	return exp.file.Close()
 }
 
-func ExportData(filename string, storage metricRepository) error {
+func ExportData(ctx context.Context, filename string, storage metricRepository) error {
 	exp, err := newExporter(filename)
 	if err != nil {
 		return err
 	}
 	defer exp.close()
 
-	err = exp.exportStorage(storage)
+	err = exp.exportStorage(ctx, storage)
 	return err
 }
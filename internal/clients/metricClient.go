@@ -1,239 +1,450 @@
 package clients
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
-	"github.com/VladimirMovsesyan/praktikum-devops/internal/crypt"
-	"github.com/VladimirMovsesyan/praktikum-devops/internal/handlers"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/collectors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/gateway"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/telemetry"
+	"github.com/VladimirMovsesyan/praktikum-devops/pkg/client"
+	"github.com/go-chi/chi/v5"
 	"log"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
-const (
-	defaultProtocol     = "http://"
-	updateGaugeFormat   = "/update/%s/%s/%f"
-	hashGaugeFormat     = "%s:%s:%f"
-	updateCounterFormat = "/update/%s/%s/%d"
-	hashCounterFormat   = "%s:%s:%d"
-)
-
-func NewMetricsClient() *http.Client {
-	client := &http.Client{}
-	client.Timeout = 3 * time.Second
-	return client
+type workerPool struct {
+	workerCnt  int
+	address    string
+	client     *client.Client
+	clientOpts []client.Option
+	storage    *repository.MemStorage
+	guarded    *repository.ReservedStorage
+	telemetry  *telemetry.Telemetry
+	schedule   []collectors.Scheduled
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	tickers    sync.WaitGroup
+	workers    sync.WaitGroup
+	httpAddr   string
+	udpAddr    string
+	httpServer *http.Server
+	listener   net.Listener
+	udpConn    net.PacketConn
+
+	telemetryAddr     string
+	telemetryServer   *http.Server
+	telemetryListener net.Listener
+
+	mu      sync.Mutex
+	retryAt time.Time
+
+	taskMu  sync.RWMutex
+	taskCh  chan Task
+	stopped bool
 }
 
-func MetricsUpload(storage metricRepository, address, key, cryptoPath string) {
-	address = defaultProtocol + address
+// WorkerPoolOption - optional setting of worker pool.
+type WorkerPoolOption func(wp *workerPool)
 
-	log.Println("sending metrics to:", address)
-	metricsUpload(storage, address, key, cryptoPath)
+// WithHashAlgorithm - makes worker pool sign metrics and requests with declared algorithm
+// instead of legacy scheme.
+func WithHashAlgorithm(alg hash.Algorithm) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.clientOpts = append(wp.clientOpts, client.WithHashAlgorithm(string(alg)))
+	}
+}
 
-	metrics.ResetPollCounter(storage)
+// WithTLSConfig - makes worker pool upload metrics over https.
+func WithTLSConfig(config *tls.Config) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.clientOpts = append(wp.clientOpts, client.WithTLSConfig(config))
+	}
 }
 
-func getRealIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		log.Println(err)
-		return ""
+// WithToken - makes worker pool authenticate with bearer token.
+func WithToken(token string) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.clientOpts = append(wp.clientOpts, client.WithToken(token))
 	}
-	defer conn.Close()
+}
 
-	addr := conn.LocalAddr().(*net.UDPAddr)
-	return addr.String()
+// WithAgentID - makes worker pool declare agent ID to server, it is recorded in audit log.
+func WithAgentID(id string) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.clientOpts = append(wp.clientOpts, client.WithAgentID(id))
+	}
 }
 
-func metricsUpload(storage metricRepository, address, key, cryptoPath string) {
-	client := NewMetricsClient()
-	url := address + "/updates/"
+// WithLocalIngest - makes worker pool accept metrics pushed by local processes over http and udp
+// in formats of server, empty address disables listener. Metrics are forwarded with the next upload.
+func WithLocalIngest(httpAddr, udpAddr string) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.httpAddr = httpAddr
+		wp.udpAddr = udpAddr
+	}
+}
 
-	metricsMap := storage.GetMetricsMap()
+// WithTelemetryListener - makes worker pool serve "/healthz" and "/metrics" of its telemetry at addr,
+// which doesn't accept metrics, empty address disables listener.
+func WithTelemetryListener(addr string) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.telemetryAddr = addr
+	}
+}
 
-	if len(metricsMap) == 0 {
-		log.Println("Empty batch, uploading skipped")
-		return
+// WithCollectors - makes worker pool poll collectors, each one with its own interval.
+func WithCollectors(scheduled ...collectors.Scheduled) WorkerPoolOption {
+	return func(wp *workerPool) {
+		wp.schedule = append(wp.schedule, scheduled...)
 	}
+}
 
-	jsonMetrics := make([]handlers.JSONMetric, 0, len(metricsMap))
+// NewWorkerPool - creates worker pool uploading metrics to server at address, metrics are signed with keys
+// and encrypted with public key at cryptoPath, if they are set.
+func NewWorkerPool(workerCnt int, address string, keys []hash.Key, cryptoPath string, opts ...WorkerPoolOption) (*workerPool, error) {
+	wp := &workerPool{
+		workerCnt: workerCnt,
+		address:   address,
+		storage:   repository.NewMemStorage(),
+		done:      make(chan struct{}),
+	}
+	// Only worker pool itself may write telemetry, collectors and local processes may not
+	wp.guarded = repository.NewReservedStorage(wp.storage, telemetry.Prefix)
+	wp.telemetry = telemetry.New(wp.storage)
 
-	for _, metric := range metricsMap {
-		var jsonMetric handlers.JSONMetric
+	for _, opt := range opts {
+		opt(wp)
+	}
 
-		switch metric.GetKind() {
-		case "gauge":
-			value := float64(metric.GetGaugeValue())
-			jsonMetric = handlers.JSONMetric{
-				ID:    metric.GetName(),
-				MType: metric.GetKind(),
-				Value: &value,
-			}
-		case "counter":
-			delta := int64(metric.GetCounterValue())
-			jsonMetric = handlers.JSONMetric{
-				ID:    metric.GetName(),
-				MType: metric.GetKind(),
-				Delta: &delta,
-			}
-		default:
-			log.Fatal("not implemented")
-		}
+	// Every collector and reporting may have one task waiting for a free worker
+	wp.taskCh = make(chan Task, len(wp.schedule)+1)
+	wp.ctx, wp.cancel = context.WithCancel(context.Background())
 
-		hashData, err := getHashData(metric)
-		if err != nil {
-			return
-		}
+	clientOpts := append([]client.Option{client.WithKeys(clientKeys(keys)...)}, wp.clientOpts...)
+	if cryptoPath != "" {
+		clientOpts = append(clientOpts, client.WithPublicKey(cryptoPath))
+	}
 
-		if key != "" {
-			jsonMetric.Hash = hash.Get(hashData, key)
-		}
-		jsonMetrics = append(jsonMetrics, jsonMetric)
+	var err error
+	wp.client, err = client.New(address, clientOpts...)
+	if err != nil {
+		return nil, err
 	}
 
-	marshal, err := json.Marshal(&jsonMetrics)
+	err = wp.listen()
 	if err != nil {
-		log.Println("Error: ", err)
-		return
+		wp.cancel()
+		wp.client.Close()
+		return nil, err
 	}
 
-	if cryptoPath != "" {
-		c, err := crypt.New(crypt.WithPublicKey(cryptoPath))
+	return wp, nil
+}
+
+// listen - opens listeners of local ingestion and telemetry, so busy ports are reported before worker pool is run.
+func (wp *workerPool) listen() error {
+	if wp.httpAddr != "" {
+		var err error
+		wp.listener, err = net.Listen("tcp", wp.httpAddr)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
+		wp.httpServer = &http.Server{Handler: gateway.NewHandler(wp.guarded), ReadHeaderTimeout: 5 * time.Second}
+	}
 
-		marshal, err = c.Encrypt(marshal)
+	if wp.telemetryAddr != "" {
+		var err error
+		wp.telemetryListener, err = net.Listen("tcp", wp.telemetryAddr)
 		if err != nil {
-			log.Println(err)
+			wp.closeListeners()
+			return err
 		}
+		router := chi.NewRouter()
+		router.Get("/healthz", wp.telemetry.HealthHandler())
+		router.Get("/metrics", wp.telemetry.MetricsHandler())
+		wp.telemetryServer = &http.Server{Handler: router, ReadHeaderTimeout: 5 * time.Second}
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(marshal))
-	if err != nil {
-		log.Println("Error: ", err)
-		return
+	if wp.udpAddr != "" {
+		var err error
+		wp.udpConn, err = net.ListenPacket("udp", wp.udpAddr)
+		if err != nil {
+			wp.closeListeners()
+			return err
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Real-IP", getRealIP())
+	return nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error: ", err)
-		return
+// closeListeners - closes listeners opened by failed listen.
+func (wp *workerPool) closeListeners() {
+	if wp.listener != nil {
+		wp.listener.Close()
+	}
+	if wp.telemetryListener != nil {
+		wp.telemetryListener.Close()
 	}
-	defer resp.Body.Close()
 }
 
-func metricUpload(address string, metric metrics.Metric, key string) {
-	client := NewMetricsClient()
+// SetKeys - replaces signing keys, e.g. on reload.
+func (wp *workerPool) SetKeys(keys ...hash.Key) error {
+	return wp.client.SetKeys(clientKeys(keys)...)
+}
 
-	url := address
+func clientKeys(keys []hash.Key) []client.Key {
+	result := make([]client.Key, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, client.Key{ID: k.ID, Secret: k.Secret, NotBefore: k.NotBefore, NotAfter: k.NotAfter})
+	}
+	return result
+}
 
-	switch metric.GetKind() {
-	case "gauge":
-		url = fmt.Sprintf(url+updateGaugeFormat, metric.GetKind(), metric.GetName(), metric.GetGaugeValue())
-	case "counter":
-		url = fmt.Sprintf(url+updateCounterFormat, metric.GetKind(), metric.GetName(), metric.GetCounterValue())
-	default:
-		log.Fatal("Error: unsupported metric type!")
+// Run - starts workers and polling of collectors, which adds collect task on every tick.
+// Collect task is cancelled if it isn't done within interval of its collector.
+func (wp *workerPool) Run() {
+	for i := 0; i < wp.workerCnt; i++ {
+		wp.workers.Add(1)
+		go func() {
+			defer wp.workers.Done()
+			for task := range wp.taskCh {
+				wp.run(task)
+			}
+		}()
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if wp.httpServer != nil {
+		log.Println("accepting local metrics over http on", wp.listener.Addr())
+		go func() {
+			err := wp.httpServer.Serve(wp.listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println(err)
+			}
+		}()
+	}
+	if wp.telemetryServer != nil {
+		log.Println("serving telemetry over http on", wp.telemetryListener.Addr())
+		go func() {
+			err := wp.telemetryServer.Serve(wp.telemetryListener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println(err)
+			}
+		}()
+	}
+	if wp.udpConn != nil {
+		log.Println("accepting local metrics over udp on", wp.udpConn.LocalAddr())
+		go func() {
+			err := gateway.ServeUDP(wp.udpConn, wp.guarded)
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	for _, s := range wp.schedule {
+		wp.tickers.Add(1)
+		go func(s collectors.Scheduled) {
+			defer wp.tickers.Done()
+
+			ticker := time.NewTicker(s.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					wp.AddTask(NewCollectTask(s.Collector, s.Interval))
+				case <-wp.done:
+					return
+				}
+			}
+		}(s)
+	}
+}
+
+func (wp *workerPool) run(task Task) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if task.timeout > 0 {
+		ctx, cancel = context.WithTimeout(wp.ctx, task.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(wp.ctx)
+	}
+	defer cancel()
+
+	switch task.kind {
+	case uploadTask:
+		wp.upload(ctx)
+	case collectTask:
+		wp.collect(ctx, task.collector)
+	}
+}
+
+// collect - stores metrics of collector, counters are added to collected earlier.
+func (wp *workerPool) collect(ctx context.Context, c collectors.Collector) {
+	log.Println("collecting", c.Name())
+	start := time.Now()
+	mtrcs, err := c.Collect(ctx)
+	if err == nil {
+		err = wp.guarded.BatchUpdate(ctx, mtrcs)
+	}
+	wp.telemetry.Collect(c.Name(), time.Since(start), err)
 	if err != nil {
-		log.Println("Error: ", err)
+		log.Println(c.Name(), err)
+	}
+}
+
+// upload - sends metrics unless server asked to wait with Retry-After.
+func (wp *workerPool) upload(ctx context.Context) {
+	wp.mu.Lock()
+	retryAt := wp.retryAt
+	wp.mu.Unlock()
+
+	if time.Now().Before(retryAt) {
+		log.Println("uploading postponed till", retryAt.Format(time.RFC3339))
 		return
 	}
 
-	hashData, err := getHashData(metric)
-	if err != nil {
+	log.Println("sending metrics to:", wp.address)
+	err := wp.push(ctx)
+	if err == nil {
 		return
 	}
 
-	req.Header.Set("Content-Type", "text/plain")
-	if key != "" {
-		req.Header.Set("Hash", hash.Get(hashData, key))
+	log.Println("Error: ", err)
+	if retryAfter := client.RetryAfter(err); retryAfter > 0 {
+		wp.mu.Lock()
+		wp.retryAt = time.Now().Add(retryAfter)
+		wp.mu.Unlock()
 	}
+}
 
-	resp, err := client.Do(req)
+// push - sends gauges and counters accumulated since previous push. Counters are taken from storage atomically,
+// so increments collected during push are sent next time, and counters which server didn't accept are returned to storage.
+// Result of push is recorded to telemetry, which is sent with the next push.
+func (wp *workerPool) push(ctx context.Context) error {
+	start := time.Now()
+	metricsMap, err := wp.storage.Drain(ctx)
 	if err != nil {
-		log.Println("Error: ", err)
-		return
+		return err
 	}
-	defer resp.Body.Close()
-}
 
-func getHashData(metric metrics.Metric) (string, error) {
-	var hashData string
-	switch metric.GetKind() {
-	case "gauge":
-		hashData = fmt.Sprintf(hashGaugeFormat, metric.GetName(), metric.GetKind(), metric.GetGaugeValue())
-	case "counter":
-		hashData = fmt.Sprintf(hashCounterFormat, metric.GetName(), metric.GetKind(), metric.GetCounterValue())
-	default:
-		log.Println("not implemented type")
-		return "", errors.New("not implemented type")
+	if len(metricsMap) == 0 {
+		log.Println("Empty batch, uploading skipped")
+		return nil
 	}
-	return hashData, nil
-}
 
-type metricRepository interface {
-	GetMetricsMap() map[string]metrics.Metric
-	GetMetric(name string) (metrics.Metric, error)
-	Update(metrics.Metric)
-	BatchUpdate(metrics []metrics.Metric)
-}
+	batch := make([]client.Metric, 0, len(metricsMap))
+	for _, metric := range metricsMap {
+		switch metric.GetKind() {
+		case client.KindGauge:
+			batch = append(batch, client.NewGauge(metric.GetName(), float64(metric.GetGaugeValue())))
+		case client.KindCounter:
+			batch = append(batch, client.NewCounter(metric.GetName(), int64(metric.GetCounterValue())))
+		}
+	}
 
-type workerPool struct {
-	workerCnt  int
-	address    string
-	key        string
-	cryptoPath string
-	storage    metricRepository
-	taskCh     chan string
-}
+	err = wp.client.Push(ctx, batch)
+	wp.telemetry.Upload(time.Since(start), len(batch), wp.client.BytesSent(), err)
 
-func NewWorkerPool(workerCnt int, address, key, cryptoPath string) *workerPool {
-	return &workerPool{
-		workerCnt:  workerCnt,
-		address:    address,
-		key:        key,
-		cryptoPath: cryptoPath,
-		storage:    repository.NewMemStorage(),
-		taskCh:     make(chan string),
+	// Chunks accepted before failure mustn't be sent again
+	var counters []metrics.Metric
+	for _, m := range client.Failed(batch, err) {
+		if m.Kind == client.KindCounter {
+			counters = append(counters, metrics.NewMetricCounter(m.Name, metrics.Counter(m.Delta)))
+		}
 	}
+	if len(counters) > 0 {
+		restoreErr := wp.storage.BatchUpdate(context.Background(), counters)
+		if restoreErr != nil {
+			log.Println(restoreErr)
+		}
+	}
+	return err
 }
 
-func (wp *workerPool) Run() {
-	for i := 0; i < wp.workerCnt; i++ {
-		go func() {
-			for task := range wp.taskCh {
-				switch task {
-				case "updateMem":
-					metrics.UpdateMetrics(wp.storage)
-				case "updateGopsutil":
-					metrics.UpdateMetricsGopsutil(wp.storage)
-				case "upload":
-					MetricsUpload(wp.storage, wp.address, wp.key, wp.cryptoPath)
-				default:
-					log.Println("not implemented type of worker pool's task")
-				}
-			}
-		}()
+// AddTask - queues task without waiting for a free worker. Task is dropped and counted in telemetry
+// if queue is full, or if worker pool is stopped. Returns whether task was queued.
+func (wp *workerPool) AddTask(task Task) bool {
+	wp.taskMu.RLock()
+	defer wp.taskMu.RUnlock()
+
+	if !wp.stopped {
+		select {
+		case wp.taskCh <- task:
+			wp.telemetry.Queued(len(wp.taskCh))
+			return true
+		default:
+		}
 	}
-}
 
-func (wp *workerPool) AddTask(task string) {
-	wp.taskCh <- task
+	log.Println("worker pool is busy, task dropped:", task.Name())
+	wp.telemetry.Dropped(task.Name())
+	return false
 }
 
-func (wp *workerPool) Stop() {
+// Stop - stops local ingestion, telemetry listener and polling of collectors, waits for queued and in-flight tasks
+// and uploads metrics the last time. Tasks which aren't done when ctx is done are cancelled, and metrics they
+// stored are uploaded.
+func (wp *workerPool) Stop(ctx context.Context) {
+	if wp.httpServer != nil {
+		err := wp.httpServer.Shutdown(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if wp.telemetryServer != nil {
+		err := wp.telemetryServer.Shutdown(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if wp.udpConn != nil {
+		err := wp.udpConn.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	close(wp.done)
+	wp.tickers.Wait()
+
+	wp.taskMu.Lock()
+	wp.stopped = true
 	close(wp.taskCh)
+	wp.taskMu.Unlock()
+
+	workersDone := make(chan struct{})
+	go func() {
+		wp.workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		log.Println("cancelling unfinished tasks")
+		wp.cancel()
+		<-workersDone
+	}
+
+	// Final upload isn't bound to ctx, so metrics are sent even if tasks took all the time, push has its own timeout
+	log.Println("final upload to:", wp.address)
+	err := wp.push(context.Background())
+	if err != nil {
+		log.Println("Error: ", err)
+	}
+
+	wp.cancel()
+	err = wp.client.Close()
+	if err != nil {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,300 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/collectors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/handlers"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_RetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		rw.Header().Set("Retry-After", "60")
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	wp, err := NewWorkerPool(1, server.Listener.Addr().String(), nil, "")
+	require.NoError(t, err)
+	require.NoError(t, wp.storage.Update(context.Background(), metrics.NewMetricGauge("Alloc", 1)))
+
+	wp.upload(context.Background())
+	wp.upload(context.Background())
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "upload must wait for Retry-After")
+	assert.True(t, wp.retryAt.After(time.Now().Add(50*time.Second)))
+}
+
+type fakeCollector struct{}
+
+func (fakeCollector) Name() string {
+	return "fake"
+}
+
+func (fakeCollector) Collect(_ context.Context) ([]metrics.Metric, error) {
+	return []metrics.Metric{metrics.NewMetricCounter("FakeCount", 1)}, nil
+}
+
+func TestWorkerPool_Collectors(t *testing.T) {
+	wp, err := NewWorkerPool(1, "localhost:0", nil, "", WithCollectors(collectors.Scheduled{
+		Collector: fakeCollector{},
+		Interval:  10 * time.Millisecond,
+	}))
+	require.NoError(t, err)
+	wp.Run()
+
+	require.Eventually(t, func() bool {
+		metric, err := wp.storage.GetMetric(context.Background(), "FakeCount")
+		return err == nil && metric.GetCounterValue() >= 2
+	}, time.Second, 5*time.Millisecond, "collector must be polled by its interval")
+
+	wp.Stop(context.Background())
+}
+
+func TestWorkerPool_LocalIngest(t *testing.T) {
+	wp, err := NewWorkerPool(1, "localhost:0", nil, "", WithLocalIngest("127.0.0.1:0", ""))
+	require.NoError(t, err)
+	wp.Run()
+
+	resp, err := http.Post("http://"+wp.listener.Addr().String()+"/update/counter/CronRuns/1", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	metric, err := wp.storage.GetMetric(context.Background(), "CronRuns")
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(1), metric.GetCounterValue(), "local metric must be merged into storage")
+
+	resp, err = http.Post("http://"+wp.listener.Addr().String()+"/update/counter/"+telemetry.Uploads+"/1", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "telemetry must not be written by local processes")
+
+	wp.Stop(context.Background())
+	_, err = http.Post("http://"+wp.listener.Addr().String()+"/update/counter/CronRuns/1", "text/plain", nil)
+	assert.Error(t, err, "listener must be closed by Stop")
+}
+
+func TestWorkerPool_TelemetryListener(t *testing.T) {
+	wp, err := NewWorkerPool(1, "localhost:0", nil, "", WithTelemetryListener("127.0.0.1:0"))
+	require.NoError(t, err)
+	wp.Run()
+	require.Nil(t, wp.listener, "telemetry mustn't open local ingestion")
+
+	require.NoError(t, wp.storage.Update(context.Background(), metrics.NewMetricCounter("CronRuns", 1)))
+	wp.upload(context.Background())
+	resp, err := http.Get("http://" + wp.telemetryListener.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), telemetry.UploadFailures+" 1\n")
+
+	resp, err = http.Get("http://" + wp.telemetryListener.Addr().String() + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Post("http://"+wp.telemetryListener.Addr().String()+"/update/counter/CronRuns/1", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode, "telemetry listener mustn't accept metrics")
+
+	wp.Stop(context.Background())
+	_, err = http.Get("http://" + wp.telemetryListener.Addr().String() + "/healthz")
+	assert.Error(t, err, "listener must be closed by Stop")
+}
+
+// Run with -race: collects and uploads of many workers must neither race nor lose or repeat increments.
+func TestWorkerPool_ConcurrentUpload(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		received int64
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests++
+		if requests%3 == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var batch []handlers.JSONMetric
+		// Handler runs on server's goroutine, where require can't stop the test
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch)) {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, m := range batch {
+			if m.ID == "FakeCount" {
+				received += *m.Delta
+			}
+		}
+	}))
+	defer server.Close()
+
+	wp, err := NewWorkerPool(1, server.Listener.Addr().String(), nil, "")
+	require.NoError(t, err)
+
+	const (
+		workers = 16
+		rounds  = 50
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				wp.collect(context.Background(), fakeCollector{})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds/5; j++ {
+				wp.upload(context.Background())
+				_, err := wp.storage.GetMetricsMap(context.Background())
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var remaining int64
+	if metric, err := wp.storage.GetMetric(context.Background(), "FakeCount"); err == nil {
+		remaining = int64(metric.GetCounterValue())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, int64(workers*rounds), received+remaining, "every increment must be sent exactly once")
+	assert.Greater(t, received, int64(0))
+}
+
+// blockingCollector - collector which returns its metric when released, or error when cancelled.
+type blockingCollector struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (blockingCollector) Name() string {
+	return "blocking"
+}
+
+func (c blockingCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	c.started <- struct{}{}
+	select {
+	case <-c.release:
+		return []metrics.Metric{metrics.NewMetricCounter("Released", 1)}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestWorkerPool_AddTask(t *testing.T) {
+	wp, err := NewWorkerPool(1, "localhost:0", nil, "")
+	require.NoError(t, err)
+
+	assert.True(t, wp.AddTask(NewUploadTask(time.Second)))
+	assert.False(t, wp.AddTask(NewUploadTask(time.Second)), "task must be dropped when queue is full")
+
+	wp.Stop(context.Background())
+	assert.False(t, wp.AddTask(NewUploadTask(time.Second)), "task must be dropped after stop")
+
+	metric, err := wp.storage.GetMetric(context.Background(), telemetry.DroppedTasks("upload"))
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Counter(2), metric.GetCounterValue())
+}
+
+func TestWorkerPool_TaskTimeout(t *testing.T) {
+	wp, err := NewWorkerPool(1, "localhost:0", nil, "")
+	require.NoError(t, err)
+	wp.Run()
+	defer wp.Stop(context.Background())
+
+	c := blockingCollector{started: make(chan struct{}, 1), release: make(chan struct{})}
+	require.True(t, wp.AddTask(NewCollectTask(c, 20*time.Millisecond)))
+	<-c.started
+
+	require.True(t, wp.AddTask(NewCollectTask(fakeCollector{}, time.Second)))
+	require.Eventually(t, func() bool {
+		_, err := wp.storage.GetMetric(context.Background(), "FakeCount")
+		return err == nil
+	}, time.Second, 5*time.Millisecond, "worker must be freed when task times out")
+}
+
+func TestWorkerPool_Stop(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var batch []handlers.JSONMetric
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch)) {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range batch {
+			if !strings.HasPrefix(m.ID, telemetry.Prefix) {
+				received = append(received, m.ID)
+			}
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		release  bool
+		received []string
+	}{
+		{name: "In-flight task is waited for", release: true, received: []string{"Released"}},
+		{name: "In-flight task is cancelled by deadline", release: false, received: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			received = nil
+			mu.Unlock()
+
+			wp, err := NewWorkerPool(1, server.Listener.Addr().String(), nil, "")
+			require.NoError(t, err)
+			wp.Run()
+
+			c := blockingCollector{started: make(chan struct{}, 1), release: make(chan struct{})}
+			require.True(t, wp.AddTask(NewCollectTask(c, 0)))
+			<-c.started
+
+			if tt.release {
+				time.AfterFunc(20*time.Millisecond, func() { close(c.release) })
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			wp.Stop(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tt.received, received, "metrics of finished tasks must be uploaded on stop")
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package clients
+
+import (
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/collectors"
+	"time"
+)
+
+type taskKind int
+
+const (
+	uploadTask taskKind = iota
+	collectTask
+)
+
+// Task - task of worker pool. Task is cancelled if it isn't done within its timeout, zero timeout means no limit.
+type Task struct {
+	kind      taskKind
+	collector collectors.Collector
+	timeout   time.Duration
+}
+
+// NewUploadTask - creates task of sending collected metrics to server.
+func NewUploadTask(timeout time.Duration) Task {
+	return Task{kind: uploadTask, timeout: timeout}
+}
+
+// NewCollectTask - creates task of polling collector.
+func NewCollectTask(c collectors.Collector, timeout time.Duration) Task {
+	return Task{kind: collectTask, collector: c, timeout: timeout}
+}
+
+// Name - returns "upload" or name of polled collector.
+func (t Task) Name() string {
+	if t.kind == collectTask {
+		return t.collector.Name()
+	}
+	return "upload"
+}
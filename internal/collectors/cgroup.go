@@ -0,0 +1,254 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupName = "cgroup"
+
+	// selfCgroup - name of agent's own cgroup in metric names.
+	selfCgroup = "self"
+
+	// unlimitedV1 - value of cgroup v1 limits which aren't set, the largest page aligned int64.
+	unlimitedV1 = 1<<63 - 4096
+)
+
+// CgroupTarget - watched cgroup, Path is relative to cgroup root.
+type CgroupTarget struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// CgroupOptions - options of cgroup collector. Without configured cgroups collector watches agent's own one.
+type CgroupOptions struct {
+	Root    string         `json:"root,omitempty"`
+	Cgroups []CgroupTarget `json:"cgroups,omitempty"`
+}
+
+// Cgroup - collects resource usage of cgroups v1 or v2 as Cgroup<Metric>_<name>:
+// gauges of memory usage and limit, pids and their limit, counters of cpu usage and throttling and oom events.
+// Limits which aren't set are not reported.
+type Cgroup struct {
+	root     string
+	v2       bool
+	selfPath string
+	targets  []CgroupTarget
+	deltas   *deltas
+}
+
+// NewCgroup - creates cgroup collector, cgroup version is detected by root.
+func NewCgroup(options json.RawMessage) (Collector, error) {
+	var opts CgroupOptions
+	if len(options) > 0 {
+		err := json.Unmarshal(options, &opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]struct{}, len(opts.Cgroups))
+	for _, t := range opts.Cgroups {
+		if t.Name == "" || t.Path == "" {
+			return nil, errors.New("cgroup name and path are required")
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("cgroup %q is configured twice", t.Name)
+		}
+		names[t.Name] = struct{}{}
+	}
+
+	if opts.Root == "" {
+		opts.Root = defaultCgroupRoot
+	}
+
+	c := &Cgroup{root: opts.Root, targets: opts.Cgroups, deltas: newDeltas(), selfPath: "/proc/self/cgroup"}
+	_, err := os.Stat(filepath.Join(c.root, "cgroup.controllers"))
+	c.v2 = err == nil
+
+	return c, nil
+}
+
+func (c *Cgroup) Name() string {
+	return cgroupName
+}
+
+func (c *Cgroup) Collect(_ context.Context) ([]metrics.Metric, error) {
+	if len(c.targets) == 0 {
+		paths, err := c.ownPaths()
+		if err != nil {
+			return nil, err
+		}
+		return c.collectCgroup(selfCgroup, func(controller string) string { return paths[controller] })
+	}
+
+	var result []metrics.Metric
+	for _, t := range c.targets {
+		mtrcs, err := c.collectCgroup(t.Name, func(string) string { return t.Path })
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mtrcs...)
+	}
+	return result, nil
+}
+
+// ownPaths - reads agent's cgroup by controllers, cgroup v2 has the only one under empty name.
+func (c *Cgroup) ownPaths() (map[string]string, error) {
+	file, err := os.Open(c.selfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// cgroupFile - path of file of controller, controllers of cgroup v1 are mounted separately.
+func (c *Cgroup) cgroupFile(path func(controller string) string, controller, name string) string {
+	if c.v2 {
+		return filepath.Join(c.root, path(""), name)
+	}
+	return filepath.Join(c.root, controller, path(controller), name)
+}
+
+func (c *Cgroup) collectCgroup(name string, path func(controller string) string) ([]metrics.Metric, error) {
+	var result []metrics.Metric
+	gauge := func(metric string, value uint64, ok bool) {
+		if ok {
+			result = append(result, metrics.NewMetricGauge(seriesName(metric, name), metrics.Gauge(value)))
+		}
+	}
+	counter := func(metric string, value uint64, ok bool) {
+		if !ok {
+			return
+		}
+		if m, ok := c.deltas.counter(seriesName(metric, name), value); ok {
+			result = append(result, m)
+		}
+	}
+	file := func(controller, file string) string {
+		return c.cgroupFile(path, controller, file)
+	}
+
+	var found bool
+	if c.v2 {
+		cpu, ok := readKeyed(file("", "cpu.stat"))
+		found = found || ok
+		counter("CgroupCPUUsageMicros", cpu["usage_usec"], ok)
+		counter("CgroupCPUPeriods", cpu["nr_periods"], ok)
+		counter("CgroupCPUThrottled", cpu["nr_throttled"], ok)
+		counter("CgroupCPUThrottledMicros", cpu["throttled_usec"], ok)
+
+		usage, ok := readValue(file("", "memory.current"))
+		found = found || ok
+		gauge("CgroupMemoryUsage", usage, ok)
+		limit, ok := readLimit(file("", "memory.max"))
+		gauge("CgroupMemoryLimit", limit, ok)
+
+		events, ok := readKeyed(file("", "memory.events"))
+		counter("CgroupOOMEvents", events["oom"], ok)
+		counter("CgroupOOMKills", events["oom_kill"], ok)
+
+		pids, ok := readValue(file("", "pids.current"))
+		found = found || ok
+		gauge("CgroupPids", pids, ok)
+		limit, ok = readLimit(file("", "pids.max"))
+		gauge("CgroupPidsLimit", limit, ok)
+	} else {
+		usage, ok := readValue(file("cpuacct", "cpuacct.usage"))
+		found = found || ok
+		counter("CgroupCPUUsageMicros", usage/1000, ok)
+
+		cpu, ok := readKeyed(file("cpu", "cpu.stat"))
+		counter("CgroupCPUPeriods", cpu["nr_periods"], ok)
+		counter("CgroupCPUThrottled", cpu["nr_throttled"], ok)
+		counter("CgroupCPUThrottledMicros", cpu["throttled_time"]/1000, ok)
+
+		memory, ok := readValue(file("memory", "memory.usage_in_bytes"))
+		found = found || ok
+		gauge("CgroupMemoryUsage", memory, ok)
+		limit, ok := readLimit(file("memory", "memory.limit_in_bytes"))
+		gauge("CgroupMemoryLimit", limit, ok)
+
+		oom, ok := readKeyed(file("memory", "memory.oom_control"))
+		_, hasKills := oom["oom_kill"]
+		counter("CgroupOOMKills", oom["oom_kill"], ok && hasKills)
+
+		pids, ok := readValue(file("pids", "pids.current"))
+		found = found || ok
+		gauge("CgroupPids", pids, ok)
+		limit, ok = readLimit(file("pids", "pids.max"))
+		gauge("CgroupPidsLimit", limit, ok)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("cgroup %q: no controller files found", name)
+	}
+	return result, nil
+}
+
+// readValue - reads file of single number, missing or malformed file isn't reported.
+func readValue(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// readLimit - reads limit, which isn't reported if it isn't set.
+func readLimit(path string) (uint64, bool) {
+	value, ok := readValue(path)
+	if !ok || value >= unlimitedV1 {
+		return 0, false
+	}
+	return value, true
+}
+
+// readKeyed - reads file of "key value" lines.
+func readKeyed(path string) (map[string]uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	result := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err == nil {
+			result[fields[0]] = value
+		}
+	}
+	return result, true
+}
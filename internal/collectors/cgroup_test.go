@@ -0,0 +1,106 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree - creates fake sysfs files by paths relative to root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+}
+
+func newTestCgroup(t *testing.T, options string) *Cgroup {
+	c, err := NewCgroup(json.RawMessage(options))
+	require.NoError(t, err)
+	return c.(*Cgroup)
+}
+
+func TestCgroup_V2(t *testing.T) {
+	root := t.TempDir()
+	cgroup := func(cpuUsage, throttled, ooms string) map[string]string {
+		return map[string]string{
+			"cgroup.controllers":       "cpu memory pids\n",
+			"app.slice/cpu.stat":       "usage_usec " + cpuUsage + "\nuser_usec 0\nnr_periods 10\nnr_throttled " + throttled + "\nthrottled_usec 500\n",
+			"app.slice/memory.current": "1048576\n",
+			"app.slice/memory.max":     "max\n",
+			"app.slice/memory.events":  "low 0\nhigh 0\nmax 3\noom " + ooms + "\noom_kill " + ooms + "\n",
+			"app.slice/pids.current":   "12\n",
+			"app.slice/pids.max":       "100\n",
+		}
+	}
+	writeTree(t, root, cgroup("1000", "1", "0"))
+
+	c := newTestCgroup(t, `{"root": "`+root+`", "cgroups": [{"name": "app", "path": "app.slice"}]}`)
+	require.True(t, c.v2)
+
+	got := collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(1048576), got["CgroupMemoryUsage_app"].GetGaugeValue())
+	assert.NotContains(t, got, "CgroupMemoryLimit_app", "unset limit must not be reported")
+	assert.Equal(t, metrics.Gauge(12), got["CgroupPids_app"].GetGaugeValue())
+	assert.Equal(t, metrics.Gauge(100), got["CgroupPidsLimit_app"].GetGaugeValue())
+	assert.NotContains(t, got, "CgroupCPUThrottled_app", "counters must be reported since the second collection")
+
+	writeTree(t, root, cgroup("3500", "4", "1"))
+	got = collectByName(t, c)
+	assert.Equal(t, metrics.Counter(2500), got["CgroupCPUUsageMicros_app"].GetCounterValue())
+	assert.Equal(t, metrics.Counter(3), got["CgroupCPUThrottled_app"].GetCounterValue())
+	assert.Equal(t, metrics.Counter(0), got["CgroupCPUThrottledMicros_app"].GetCounterValue())
+	assert.Equal(t, metrics.Counter(1), got["CgroupOOMEvents_app"].GetCounterValue())
+	assert.Equal(t, metrics.Counter(1), got["CgroupOOMKills_app"].GetCounterValue())
+}
+
+func TestCgroup_V1Self(t *testing.T) {
+	root := t.TempDir()
+	cgroup := func(cpuUsage, kills string) map[string]string {
+		return map[string]string{
+			"cpuacct/docker/abc/cpuacct.usage":        cpuUsage + "\n",
+			"cpu/docker/abc/cpu.stat":                 "nr_periods 5\nnr_throttled 2\nthrottled_time 7000\n",
+			"memory/docker/abc/memory.usage_in_bytes": "4096\n",
+			"memory/docker/abc/memory.limit_in_bytes": "8192\n",
+			"memory/docker/abc/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\noom_kill " + kills + "\n",
+			"pids/docker/abc/pids.current":            "3\n",
+			"pids/docker/abc/pids.max":                "max\n",
+		}
+	}
+	writeTree(t, root, cgroup("2000000", "0"))
+	self := filepath.Join(t.TempDir(), "cgroup")
+	require.NoError(t, os.WriteFile(self, []byte("12:pids:/docker/abc\n4:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n0::/\n"), 0600))
+
+	c := newTestCgroup(t, `{"root": "`+root+`"}`)
+	require.False(t, c.v2)
+	c.selfPath = self
+
+	got := collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(4096), got["CgroupMemoryUsage_self"].GetGaugeValue())
+	assert.Equal(t, metrics.Gauge(8192), got["CgroupMemoryLimit_self"].GetGaugeValue())
+	assert.Equal(t, metrics.Gauge(3), got["CgroupPids_self"].GetGaugeValue())
+	assert.NotContains(t, got, "CgroupPidsLimit_self")
+
+	writeTree(t, root, cgroup("5000000", "2"))
+	got = collectByName(t, c)
+	assert.Equal(t, metrics.Counter(3000), got["CgroupCPUUsageMicros_self"].GetCounterValue())
+	assert.Equal(t, metrics.Counter(2), got["CgroupOOMKills_self"].GetCounterValue())
+	assert.NotContains(t, got, "CgroupOOMEvents_self", "cgroup v1 doesn't count oom events")
+}
+
+func TestCgroup_Missing(t *testing.T) {
+	root := t.TempDir()
+	c := newTestCgroup(t, `{"root": "`+root+`", "cgroups": [{"name": "app", "path": "app.slice"}]}`)
+
+	_, err := c.Collect(context.Background())
+	assert.Error(t, err)
+
+	_, err = NewCgroup(json.RawMessage(`{"cgroups": [{"name": "app"}]}`))
+	assert.Error(t, err, "path is required")
+}
@@ -0,0 +1,122 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"sort"
+	"time"
+)
+
+// Collector - source of agent metrics. Counters are returned as increments since previous collection.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]metrics.Metric, error)
+}
+
+// Factory - creates collector from its options in agent config, options are nil if config has none.
+type Factory func(options json.RawMessage) (Collector, error)
+
+// Settings - config of one collector. Collector without settings is enabled if it is enabled by default,
+// and is polled with agent's poll interval.
+type Settings struct {
+	Enabled  *bool           `json:"enabled,omitempty"`
+	Interval string          `json:"interval,omitempty"`
+	Options  json.RawMessage `json:"options,omitempty"`
+}
+
+// Scheduled - collector with its poll interval.
+type Scheduled struct {
+	Collector Collector
+	Interval  time.Duration
+}
+
+type registration struct {
+	factory Factory
+	enabled bool
+}
+
+// Registry - known collectors by name.
+type Registry struct {
+	collectors map[string]registration
+}
+
+// NewRegistry - creates empty registry.
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]registration)}
+}
+
+// Default - returns registry of collectors built into agent.
+func Default() *Registry {
+	r := NewRegistry()
+	r.Register(runtimeName, true, NewRuntime)
+	r.Register(gopsutilName, true, NewGopsutil)
+	r.Register(processName, false, NewProcess)
+	r.Register(cgroupName, false, NewCgroup)
+	r.Register(scrapeName, false, NewScrape)
+	return r
+}
+
+// Register - adds collector to registry, registering the same name twice is a programming error.
+func (r *Registry) Register(name string, enabledByDefault bool, factory Factory) {
+	if _, ok := r.collectors[name]; ok {
+		panic(fmt.Sprintf("collector %q is already registered", name))
+	}
+	r.collectors[name] = registration{factory: factory, enabled: enabledByDefault}
+}
+
+// Names - returns sorted names of registered collectors.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build - creates enabled collectors in order of names. Settings of unknown collectors are an error,
+// so typos in config don't silently disable anything.
+func (r *Registry) Build(settings map[string]Settings, defaultInterval time.Duration) ([]Scheduled, error) {
+	for name := range settings {
+		if _, ok := r.collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	var result []Scheduled
+	for _, name := range r.Names() {
+		reg := r.collectors[name]
+		s := settings[name]
+
+		enabled := reg.enabled
+		if s.Enabled != nil {
+			enabled = *s.Enabled
+		}
+		if !enabled {
+			continue
+		}
+
+		interval := defaultInterval
+		if s.Interval != "" {
+			var err error
+			interval, err = time.ParseDuration(s.Interval)
+			if err != nil {
+				return nil, fmt.Errorf("collector %q: %w", name, err)
+			}
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("collector %q: interval must be positive", name)
+		}
+
+		c, err := reg.factory(s.Options)
+		if err != nil {
+			return nil, fmt.Errorf("collector %q: %w", name, err)
+		}
+
+		result = append(result, Scheduled{Collector: c, Interval: interval})
+	}
+
+	return result, nil
+}
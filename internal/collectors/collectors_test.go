@@ -0,0 +1,99 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type stub struct {
+	name    string
+	options json.RawMessage
+}
+
+func (s stub) Name() string {
+	return s.name
+}
+
+func (s stub) Collect(_ context.Context) ([]metrics.Metric, error) {
+	return nil, nil
+}
+
+func newTestRegistry() *Registry {
+	r := NewRegistry()
+	for _, name := range []string{"on", "off"} {
+		name := name
+		r.Register(name, name == "on", func(options json.RawMessage) (Collector, error) {
+			return stub{name: name, options: options}, nil
+		})
+	}
+	return r
+}
+
+func TestRegistry_Build(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name      string
+		settings  map[string]Settings
+		want      map[string]time.Duration
+		wantError bool
+	}{
+		{name: "Defaults", want: map[string]time.Duration{"on": time.Second}},
+		{
+			name:     "Enabled and disabled by config",
+			settings: map[string]Settings{"on": {Enabled: &disabled}, "off": {Enabled: &enabled}},
+			want:     map[string]time.Duration{"off": time.Second},
+		},
+		{
+			name:     "Own interval",
+			settings: map[string]Settings{"on": {Interval: "5s"}},
+			want:     map[string]time.Duration{"on": 5 * time.Second},
+		},
+		{name: "Unknown collector", settings: map[string]Settings{"typo": {}}, wantError: true},
+		{name: "Invalid interval", settings: map[string]Settings{"on": {Interval: "often"}}, wantError: true},
+		{name: "Negative interval", settings: map[string]Settings{"on": {Interval: "-1s"}}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduled, err := newTestRegistry().Build(tt.settings, time.Second)
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			got := make(map[string]time.Duration)
+			for _, s := range scheduled {
+				got[s.Collector.Name()] = s.Interval
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRegistry_BuildOptions(t *testing.T) {
+	scheduled, err := newTestRegistry().Build(map[string]Settings{"on": {Options: json.RawMessage(`{"a":1}`)}}, time.Second)
+	require.NoError(t, err)
+	require.Len(t, scheduled, 1)
+	assert.JSONEq(t, `{"a":1}`, string(scheduled[0].Collector.(stub).options))
+}
+
+func TestRuntime_Collect(t *testing.T) {
+	mtrcs, err := Runtime{}.Collect(context.Background())
+	require.NoError(t, err)
+
+	got := make(map[string]metrics.Metric, len(mtrcs))
+	for _, metric := range mtrcs {
+		got[metric.GetName()] = metric
+	}
+	pollCount := got["PollCount"]
+	assert.Equal(t, metrics.Counter(1), pollCount.GetCounterValue(), "PollCount must be increment of single poll")
+	assert.Contains(t, got, "Alloc")
+	assert.Contains(t, got, "RandomValue")
+}
@@ -0,0 +1,76 @@
+package collectors
+
+import (
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// deltas - turns cumulative counters of system into increments since previous collection,
+// as counters of agent are summed up by storage.
+type deltas struct {
+	mu   sync.Mutex
+	prev map[string]uint64
+}
+
+func newDeltas() *deltas {
+	return &deltas{prev: make(map[string]uint64)}
+}
+
+// counter - returns increment of counter. The first value is only remembered, because increment
+// since system start doesn't belong to any interval. Counter which went back was reset, so its value is the increment.
+func (d *deltas) counter(name string, value uint64) (metrics.Metric, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prev, ok := d.prev[name]
+	d.prev[name] = value
+	if !ok {
+		return metrics.Metric{}, false
+	}
+
+	delta := value
+	if value >= prev {
+		delta = value - prev
+	}
+	return metrics.NewMetricCounter(name, metrics.Counter(delta)), true
+}
+
+// seriesName - name of metric of labeled object like disk or network interface.
+// Label is reduced to characters safe in url path, mount point "/" is named "root".
+func seriesName(prefix, label string) string {
+	label = strings.Trim(label, "/")
+	if label == "" {
+		label = "root"
+	}
+
+	return capName(prefix + "_" + sanitize(label))
+}
+
+// capName - shortens name to length accepted by server. Truncated name ends with hash of full name,
+// so names with common prefix stay distinct. Names are sanitized, so truncation doesn't split characters.
+func capName(name string) string {
+	if len(name) <= wire.MaxNameLength {
+		return name
+	}
+
+	sum := fnv.New32a()
+	sum.Write([]byte(name))
+	suffix := fmt.Sprintf("_%08x", sum.Sum32())
+	return name[:wire.MaxNameLength-len(suffix)] + suffix
+}
+
+// sanitize - replaces characters unsafe in url path with underscore.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
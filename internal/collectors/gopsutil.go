@@ -0,0 +1,291 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gopsutilName = "gopsutil"
+
+	// cpuSampling - time cpu utilization is measured over.
+	cpuSampling = 100 * time.Millisecond
+)
+
+// Groups of host metrics, every group is enabled unless it is disabled in options.
+const (
+	GroupCPU       = "cpu"
+	GroupMemory    = "memory"
+	GroupSwap      = "swap"
+	GroupLoad      = "load"
+	GroupDisk      = "disk"
+	GroupDiskIO    = "diskio"
+	GroupNet       = "net"
+	GroupProcesses = "processes"
+	GroupFDs       = "fds"
+)
+
+// GopsutilOptions - options of gopsutil collector, e.g. {"groups": {"diskio": false}}.
+type GopsutilOptions struct {
+	Groups map[string]bool `json:"groups,omitempty"`
+}
+
+// Gopsutil - collects host metrics by groups. Metrics of disks and network interfaces are named
+// "<Metric>_<mount point or device>", IO counters are sent as increments since previous collection.
+type Gopsutil struct {
+	groups   []string
+	deltas   *deltas
+	hostProc string
+}
+
+type groupCollector func(g *Gopsutil, ctx context.Context) ([]metrics.Metric, error)
+
+var gopsutilGroups = map[string]groupCollector{
+	GroupCPU:       (*Gopsutil).collectCPU,
+	GroupMemory:    (*Gopsutil).collectMemory,
+	GroupSwap:      (*Gopsutil).collectSwap,
+	GroupLoad:      (*Gopsutil).collectLoad,
+	GroupDisk:      (*Gopsutil).collectDisk,
+	GroupDiskIO:    (*Gopsutil).collectDiskIO,
+	GroupNet:       (*Gopsutil).collectNet,
+	GroupProcesses: (*Gopsutil).collectProcesses,
+	GroupFDs:       (*Gopsutil).collectFDs,
+}
+
+// NewGopsutil - creates gopsutil collector.
+func NewGopsutil(options json.RawMessage) (Collector, error) {
+	var opts GopsutilOptions
+	if len(options) > 0 {
+		err := json.Unmarshal(options, &opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for group := range opts.Groups {
+		if _, ok := gopsutilGroups[group]; !ok {
+			return nil, fmt.Errorf("unknown group %q", group)
+		}
+	}
+
+	g := &Gopsutil{deltas: newDeltas(), hostProc: os.Getenv("HOST_PROC")}
+	if g.hostProc == "" {
+		g.hostProc = "/proc"
+	}
+	for group := range gopsutilGroups {
+		if enabled, ok := opts.Groups[group]; !ok || enabled {
+			g.groups = append(g.groups, group)
+		}
+	}
+	sort.Strings(g.groups)
+
+	return g, nil
+}
+
+func (g *Gopsutil) Name() string {
+	return gopsutilName
+}
+
+// Collect - collects enabled groups. Failed group is logged and skipped, so metrics unsupported
+// by platform don't hide the rest; error is returned only if every group failed.
+func (g *Gopsutil) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	var (
+		result  []metrics.Metric
+		lastErr error
+		failed  int
+	)
+	for _, group := range g.groups {
+		mtrcs, err := gopsutilGroups[group](g, ctx)
+		if err != nil {
+			log.Println(gopsutilName, group, err)
+			lastErr = err
+			failed++
+			continue
+		}
+		result = append(result, mtrcs...)
+	}
+
+	if failed > 0 && failed == len(g.groups) {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+func (g *Gopsutil) collectCPU(ctx context.Context) ([]metrics.Metric, error) {
+	percent, err := cpu.PercentWithContext(ctx, cpuSampling, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]metrics.Metric, 0, len(percent))
+	for i, p := range percent {
+		result = append(result, metrics.NewMetricGauge(fmt.Sprintf("CPUutilization%d", i+1), metrics.Gauge(p)))
+	}
+	return result, nil
+}
+
+func (g *Gopsutil) collectMemory(ctx context.Context) ([]metrics.Metric, error) {
+	m, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge("TotalMemory", metrics.Gauge(m.Total)),
+		metrics.NewMetricGauge("FreeMemory", metrics.Gauge(m.Free)),
+		metrics.NewMetricGauge("AvailableMemory", metrics.Gauge(m.Available)),
+		metrics.NewMetricGauge("UsedMemory", metrics.Gauge(m.Used)),
+	}, nil
+}
+
+func (g *Gopsutil) collectSwap(ctx context.Context) ([]metrics.Metric, error) {
+	s, err := mem.SwapMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge("TotalSwap", metrics.Gauge(s.Total)),
+		metrics.NewMetricGauge("FreeSwap", metrics.Gauge(s.Free)),
+		metrics.NewMetricGauge("UsedSwap", metrics.Gauge(s.Used)),
+	}, nil
+}
+
+func (g *Gopsutil) collectLoad(ctx context.Context) ([]metrics.Metric, error) {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge("Load1", metrics.Gauge(avg.Load1)),
+		metrics.NewMetricGauge("Load5", metrics.Gauge(avg.Load5)),
+		metrics.NewMetricGauge("Load15", metrics.Gauge(avg.Load15)),
+	}, nil
+}
+
+func (g *Gopsutil) collectDisk(ctx context.Context) ([]metrics.Metric, error) {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	for _, p := range partitions {
+		usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			log.Println(gopsutilName, p.Mountpoint, err)
+			continue
+		}
+
+		result = append(
+			result,
+			metrics.NewMetricGauge(seriesName("DiskTotal", p.Mountpoint), metrics.Gauge(usage.Total)),
+			metrics.NewMetricGauge(seriesName("DiskFree", p.Mountpoint), metrics.Gauge(usage.Free)),
+			metrics.NewMetricGauge(seriesName("DiskUsed", p.Mountpoint), metrics.Gauge(usage.Used)),
+		)
+	}
+	return result, nil
+}
+
+func (g *Gopsutil) collectDiskIO(ctx context.Context) ([]metrics.Metric, error) {
+	counters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	for device, c := range counters {
+		result = g.appendCounters(result, device, map[string]uint64{
+			"DiskReadBytes":  c.ReadBytes,
+			"DiskWriteBytes": c.WriteBytes,
+			"DiskReads":      c.ReadCount,
+			"DiskWrites":     c.WriteCount,
+		})
+	}
+	return result, nil
+}
+
+func (g *Gopsutil) collectNet(ctx context.Context) ([]metrics.Metric, error) {
+	counters, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	for _, c := range counters {
+		result = g.appendCounters(result, c.Name, map[string]uint64{
+			"NetBytesSent": c.BytesSent,
+			"NetBytesRecv": c.BytesRecv,
+			"NetErrIn":     c.Errin,
+			"NetErrOut":    c.Errout,
+			"NetDropIn":    c.Dropin,
+			"NetDropOut":   c.Dropout,
+		})
+	}
+	return result, nil
+}
+
+func (g *Gopsutil) appendCounters(result []metrics.Metric, label string, counters map[string]uint64) []metrics.Metric {
+	for prefix, value := range counters {
+		if metric, ok := g.deltas.counter(seriesName(prefix, label), value); ok {
+			result = append(result, metric)
+		}
+	}
+	return result
+}
+
+func (g *Gopsutil) collectProcesses(ctx context.Context) ([]metrics.Metric, error) {
+	misc, err := load.MiscWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge("ProcsTotal", metrics.Gauge(misc.ProcsTotal)),
+		metrics.NewMetricGauge("ProcsRunning", metrics.Gauge(misc.ProcsRunning)),
+		metrics.NewMetricGauge("ProcsBlocked", metrics.Gauge(misc.ProcsBlocked)),
+	}, nil
+}
+
+// collectFDs - reads allocated and maximum number of file descriptors of host, it is supported only by linux.
+func (g *Gopsutil) collectFDs(_ context.Context) ([]metrics.Metric, error) {
+	data, err := os.ReadFile(filepath.Join(g.hostProc, "sys", "fs", "file-nr"))
+	if err != nil {
+		return nil, err
+	}
+
+	// file-nr contains allocated, unused (always 0 since linux 2.6) and maximum number of descriptors.
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected file-nr format %q", data)
+	}
+
+	allocated, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	maximum, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge("FileDescriptors", metrics.Gauge(allocated)),
+		metrics.NewMetricGauge("FileDescriptorsMax", metrics.Gauge(maximum)),
+	}, nil
+}
@@ -0,0 +1,99 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewGopsutil(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   string
+		disabled  []string
+		wantError bool
+	}{
+		{name: "All groups by default"},
+		{name: "Disabled groups", options: `{"groups": {"disk": false, "diskio": false, "cpu": true}}`, disabled: []string{GroupDisk, GroupDiskIO}},
+		{name: "Unknown group", options: `{"groups": {"gpu": true}}`, wantError: true},
+		{name: "Invalid options", options: `[]`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var options json.RawMessage
+			if tt.options != "" {
+				options = json.RawMessage(tt.options)
+			}
+
+			c, err := NewGopsutil(options)
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			groups := c.(*Gopsutil).groups
+			assert.Len(t, groups, len(gopsutilGroups)-len(tt.disabled))
+			for _, group := range tt.disabled {
+				assert.NotContains(t, groups, group)
+			}
+		})
+	}
+}
+
+func TestGopsutil_CollectFDs(t *testing.T) {
+	hostProc := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(hostProc, "sys", "fs"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(hostProc, "sys", "fs", "file-nr"), []byte("1024\t0\t65536\n"), 0600))
+
+	g := &Gopsutil{groups: []string{GroupFDs}, deltas: newDeltas(), hostProc: hostProc}
+	mtrcs, err := g.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []metrics.Metric{
+		metrics.NewMetricGauge("FileDescriptors", 1024),
+		metrics.NewMetricGauge("FileDescriptorsMax", 65536),
+	}, mtrcs)
+
+	g.hostProc = t.TempDir()
+	_, err = g.Collect(context.Background())
+	assert.Error(t, err, "error must be returned if every group failed")
+}
+
+func TestDeltas(t *testing.T) {
+	d := newDeltas()
+
+	_, ok := d.counter("NetBytesRecv_eth0", 100)
+	assert.False(t, ok, "the first value must only be remembered")
+
+	metric, ok := d.counter("NetBytesRecv_eth0", 150)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Counter(50), metric.GetCounterValue())
+
+	metric, ok = d.counter("NetBytesRecv_eth0", 20)
+	require.True(t, ok)
+	assert.Equal(t, metrics.Counter(20), metric.GetCounterValue(), "reset counter must be counted from zero")
+}
+
+func TestSeriesName(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{label: "/", want: "DiskFree_root"},
+		{label: "/var/lib/docker", want: "DiskFree_var_lib_docker"},
+		{label: "sda1", want: "DiskFree_sda1"},
+		{label: "C:", want: "DiskFree_C_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			assert.Equal(t, tt.want, seriesName("DiskFree", tt.label))
+		})
+	}
+}
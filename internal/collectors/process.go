@@ -0,0 +1,258 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/shirou/gopsutil/process"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	processName = "process"
+
+	defaultCgroupRoot = "/sys/fs/cgroup"
+)
+
+// ProcessTarget - watched process, it is selected by exactly one of executable name, pid file or cgroup.
+// All processes matching target are summed up, Name is used in metric names.
+type ProcessTarget struct {
+	Name    string `json:"name"`
+	Process string `json:"process,omitempty"`
+	PIDFile string `json:"pid_file,omitempty"`
+	Cgroup  string `json:"cgroup,omitempty"`
+}
+
+// ProcessOptions - options of process collector. Cgroups are relative to CgroupRoot, "/sys/fs/cgroup" by default.
+type ProcessOptions struct {
+	Processes  []ProcessTarget `json:"processes"`
+	CgroupRoot string          `json:"cgroup_root,omitempty"`
+}
+
+// procID - identifies process across pid reuse.
+type procID struct {
+	pid        int32
+	createTime int64
+}
+
+type procSample struct {
+	cpu float64
+	at  time.Time
+}
+
+type processState struct {
+	main    procID
+	samples map[procID]procSample
+}
+
+// Process - collects metrics of watched processes:
+// Process<Metric>_<name> gauges of count, cpu percent, rss, threads and open files,
+// and ProcessRestarts_<name> counter, which is incremented when the oldest process of target is replaced.
+type Process struct {
+	targets    []ProcessTarget
+	cgroupRoot string
+	now        func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*processState
+}
+
+// NewProcess - creates process collector, at least one process must be configured.
+func NewProcess(options json.RawMessage) (Collector, error) {
+	var opts ProcessOptions
+	if len(options) > 0 {
+		err := json.Unmarshal(options, &opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.Processes) == 0 {
+		return nil, errors.New("no processes configured")
+	}
+
+	names := make(map[string]struct{}, len(opts.Processes))
+	for _, t := range opts.Processes {
+		if t.Name == "" {
+			return nil, errors.New("process name is required")
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("process %q is configured twice", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		selectors := 0
+		for _, s := range []string{t.Process, t.PIDFile, t.Cgroup} {
+			if s != "" {
+				selectors++
+			}
+		}
+		if selectors != 1 {
+			return nil, fmt.Errorf("process %q must be selected by exactly one of process, pid_file and cgroup", t.Name)
+		}
+	}
+
+	if opts.CgroupRoot == "" {
+		opts.CgroupRoot = defaultCgroupRoot
+	}
+
+	return &Process{
+		targets:    opts.Processes,
+		cgroupRoot: opts.CgroupRoot,
+		now:        time.Now,
+		states:     make(map[string]*processState),
+	}, nil
+}
+
+func (p *Process) Name() string {
+	return processName
+}
+
+func (p *Process) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result []metrics.Metric
+	for _, t := range p.targets {
+		pids, err := p.pids(ctx, t)
+		if err != nil {
+			// Missing pid file or cgroup means process isn't running.
+			log.Println(processName, t.Name, err)
+		}
+		result = append(result, p.collectTarget(ctx, t.Name, pids)...)
+	}
+	return result, nil
+}
+
+func (p *Process) pids(ctx context.Context, t ProcessTarget) ([]int32, error) {
+	switch {
+	case t.PIDFile != "":
+		data, err := os.ReadFile(t.PIDFile)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.PIDFile, err)
+		}
+		return []int32{int32(pid)}, nil
+	case t.Cgroup != "":
+		return cgroupPids(filepath.Join(p.cgroupRoot, t.Cgroup))
+	default:
+		processes, err := process.ProcessesWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var pids []int32
+		for _, proc := range processes {
+			name, err := proc.NameWithContext(ctx)
+			if err == nil && name == t.Process {
+				pids = append(pids, proc.Pid)
+			}
+		}
+		return pids, nil
+	}
+}
+
+// cgroupPids - reads processes of cgroup from cgroup.procs.
+func cgroupPids(dir string) ([]int32, error) {
+	file, err := os.Open(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pids []int32
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pid, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+		pids = append(pids, int32(pid))
+	}
+	return pids, scanner.Err()
+}
+
+// collectTarget - sums up metrics of processes. Cpu percent of process is measured since its previous collection,
+// so process seen for the first time adds nothing to it.
+func (p *Process) collectTarget(ctx context.Context, name string, pids []int32) []metrics.Metric {
+	state, ok := p.states[name]
+	if !ok {
+		state = &processState{samples: make(map[procID]procSample)}
+		p.states[name] = state
+	}
+
+	var (
+		count, threads, openFiles int
+		rss                       uint64
+		cpuPercent                float64
+		main                      procID
+	)
+	now := p.now()
+	samples := make(map[procID]procSample, len(pids))
+	for _, pid := range pids {
+		proc, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			continue
+		}
+		createTime, err := proc.CreateTimeWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		id := procID{pid: pid, createTime: createTime}
+
+		times, err := proc.TimesWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		sample := procSample{cpu: times.User + times.System, at: now}
+		samples[id] = sample
+		if prev, ok := state.samples[id]; ok && sample.at.After(prev.at) {
+			cpuPercent += (sample.cpu - prev.cpu) / sample.at.Sub(prev.at).Seconds() * 100
+		}
+
+		if mem, err := proc.MemoryInfoWithContext(ctx); err == nil {
+			rss += mem.RSS
+		}
+		if n, err := proc.NumThreadsWithContext(ctx); err == nil {
+			threads += int(n)
+		}
+		if n, err := proc.NumFDsWithContext(ctx); err == nil {
+			openFiles += int(n)
+		}
+
+		count++
+		if main.pid == 0 || id.createTime < main.createTime {
+			main = id
+		}
+	}
+	state.samples = samples
+
+	var restarts metrics.Counter
+	if count > 0 {
+		if _, alive := samples[state.main]; state.main.pid != 0 && !alive {
+			restarts = 1
+		}
+		state.main = main
+	}
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge(seriesName("ProcessCount", name), metrics.Gauge(count)),
+		metrics.NewMetricGauge(seriesName("ProcessCPU", name), metrics.Gauge(cpuPercent)),
+		metrics.NewMetricGauge(seriesName("ProcessRSS", name), metrics.Gauge(rss)),
+		metrics.NewMetricGauge(seriesName("ProcessThreads", name), metrics.Gauge(threads)),
+		metrics.NewMetricGauge(seriesName("ProcessOpenFiles", name), metrics.Gauge(openFiles)),
+		metrics.NewMetricCounter(seriesName("ProcessRestarts", name), restarts),
+	}
+}
@@ -0,0 +1,115 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   string
+		wantError bool
+	}{
+		{name: "Valid", options: `{"processes": [{"name": "nginx", "process": "nginx"}, {"name": "db", "pid_file": "/run/db.pid"}]}`},
+		{name: "No processes", options: `{}`, wantError: true},
+		{name: "No name", options: `{"processes": [{"process": "nginx"}]}`, wantError: true},
+		{name: "Duplicate name", options: `{"processes": [{"name": "a", "process": "a"}, {"name": "a", "cgroup": "a"}]}`, wantError: true},
+		{name: "No selector", options: `{"processes": [{"name": "a"}]}`, wantError: true},
+		{name: "Several selectors", options: `{"processes": [{"name": "a", "process": "a", "pid_file": "/run/a.pid"}]}`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProcess(json.RawMessage(tt.options))
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func collectByName(t *testing.T, c Collector) map[string]*metrics.Metric {
+	mtrcs, err := c.Collect(context.Background())
+	require.NoError(t, err)
+
+	result := make(map[string]*metrics.Metric, len(mtrcs))
+	for i := range mtrcs {
+		result[mtrcs[i].GetName()] = &mtrcs[i]
+	}
+	return result
+}
+
+func TestProcess_PIDFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "agent.pid")
+	writePID := func(pid int) {
+		require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(pid)+"\n"), 0600))
+	}
+	writePID(os.Getpid())
+
+	c, err := NewProcess(json.RawMessage(`{"processes": [{"name": "self", "pid_file": "` + pidFile + `"}]}`))
+	require.NoError(t, err)
+	now := time.Now()
+	c.(*Process).now = func() time.Time { return now }
+
+	got := collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(1), got["ProcessCount_self"].GetGaugeValue())
+	assert.Greater(t, got["ProcessRSS_self"].GetGaugeValue(), metrics.Gauge(0))
+	assert.Greater(t, got["ProcessThreads_self"].GetGaugeValue(), metrics.Gauge(0))
+	assert.Greater(t, got["ProcessOpenFiles_self"].GetGaugeValue(), metrics.Gauge(0))
+	assert.Equal(t, metrics.Counter(0), got["ProcessRestarts_self"].GetCounterValue())
+
+	now = now.Add(time.Second)
+	got = collectByName(t, c)
+	assert.GreaterOrEqual(t, got["ProcessCPU_self"].GetGaugeValue(), metrics.Gauge(0))
+	assert.Equal(t, metrics.Counter(0), got["ProcessRestarts_self"].GetCounterValue(), "the same process must not be a restart")
+
+	require.NoError(t, os.Remove(pidFile))
+	got = collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(0), got["ProcessCount_self"].GetGaugeValue(), "missing pid file means process is down")
+
+	writePID(os.Getppid())
+	got = collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(1), got["ProcessCount_self"].GetGaugeValue())
+	assert.Equal(t, metrics.Counter(1), got["ProcessRestarts_self"].GetCounterValue(), "another process must be a restart")
+}
+
+func TestProcess_Cgroup(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "system.slice", "agent.service")
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	procs := strconv.Itoa(os.Getpid()) + "\n" + strconv.Itoa(os.Getppid()) + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(procs), 0600))
+
+	c, err := NewProcess(json.RawMessage(`{"cgroup_root": "` + root + `", "processes": [{"name": "agent", "cgroup": "system.slice/agent.service"}]}`))
+	require.NoError(t, err)
+
+	got := collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(2), got["ProcessCount_agent"].GetGaugeValue())
+}
+
+func TestProcess_Name(t *testing.T) {
+	self, err := os.Executable()
+	require.NoError(t, err)
+	// Linux truncates process name to 15 characters.
+	name := filepath.Base(self)
+	if len(name) > 15 {
+		name = name[:15]
+	}
+
+	c, err := NewProcess(json.RawMessage(`{"processes": [{"name": "test", "process": "` + name + `"}]}`))
+	require.NoError(t, err)
+
+	got := collectByName(t, c)
+	assert.GreaterOrEqual(t, got["ProcessCount_test"].GetGaugeValue(), metrics.Gauge(1))
+}
@@ -0,0 +1,61 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"math"
+	"math/rand"
+	"runtime"
+)
+
+const runtimeName = "runtime"
+
+// Runtime - collects runtime.MemStats of agent, PollCount and RandomValue.
+type Runtime struct{}
+
+// NewRuntime - creates runtime collector, it has no options.
+func NewRuntime(_ json.RawMessage) (Collector, error) {
+	return Runtime{}, nil
+}
+
+func (Runtime) Name() string {
+	return runtimeName
+}
+
+func (Runtime) Collect(_ context.Context) ([]metrics.Metric, error) {
+	runtimeMetrics := runtime.MemStats{}
+	runtime.ReadMemStats(&runtimeMetrics)
+
+	return []metrics.Metric{
+		metrics.NewMetricGauge("Alloc", metrics.Gauge(runtimeMetrics.Alloc)),
+		metrics.NewMetricGauge("BuckHashSys", metrics.Gauge(runtimeMetrics.BuckHashSys)),
+		metrics.NewMetricGauge("Frees", metrics.Gauge(runtimeMetrics.Frees)),
+		metrics.NewMetricGauge("GCCPUFraction", metrics.Gauge(runtimeMetrics.GCCPUFraction)),
+		metrics.NewMetricGauge("GCSys", metrics.Gauge(runtimeMetrics.GCSys)),
+		metrics.NewMetricGauge("HeapAlloc", metrics.Gauge(runtimeMetrics.HeapAlloc)),
+		metrics.NewMetricGauge("HeapIdle", metrics.Gauge(runtimeMetrics.HeapIdle)),
+		metrics.NewMetricGauge("HeapInuse", metrics.Gauge(runtimeMetrics.HeapInuse)),
+		metrics.NewMetricGauge("HeapObjects", metrics.Gauge(runtimeMetrics.HeapObjects)),
+		metrics.NewMetricGauge("HeapReleased", metrics.Gauge(runtimeMetrics.HeapReleased)),
+		metrics.NewMetricGauge("HeapSys", metrics.Gauge(runtimeMetrics.HeapSys)),
+		metrics.NewMetricGauge("LastGC", metrics.Gauge(runtimeMetrics.LastGC)),
+		metrics.NewMetricGauge("Lookups", metrics.Gauge(runtimeMetrics.Lookups)),
+		metrics.NewMetricGauge("MCacheInuse", metrics.Gauge(runtimeMetrics.MCacheInuse)),
+		metrics.NewMetricGauge("MCacheSys", metrics.Gauge(runtimeMetrics.MCacheSys)),
+		metrics.NewMetricGauge("MSpanInuse", metrics.Gauge(runtimeMetrics.MSpanInuse)),
+		metrics.NewMetricGauge("MSpanSys", metrics.Gauge(runtimeMetrics.MSpanSys)),
+		metrics.NewMetricGauge("Mallocs", metrics.Gauge(runtimeMetrics.Mallocs)),
+		metrics.NewMetricGauge("NextGC", metrics.Gauge(runtimeMetrics.NextGC)),
+		metrics.NewMetricGauge("NumForcedGC", metrics.Gauge(runtimeMetrics.NumForcedGC)),
+		metrics.NewMetricGauge("NumGC", metrics.Gauge(runtimeMetrics.NumGC)),
+		metrics.NewMetricGauge("OtherSys", metrics.Gauge(runtimeMetrics.OtherSys)),
+		metrics.NewMetricGauge("PauseTotalNs", metrics.Gauge(runtimeMetrics.PauseTotalNs)),
+		metrics.NewMetricGauge("StackInuse", metrics.Gauge(runtimeMetrics.StackInuse)),
+		metrics.NewMetricGauge("StackSys", metrics.Gauge(runtimeMetrics.StackSys)),
+		metrics.NewMetricGauge("Sys", metrics.Gauge(runtimeMetrics.Sys)),
+		metrics.NewMetricGauge("TotalAlloc", metrics.Gauge(runtimeMetrics.TotalAlloc)),
+		metrics.NewMetricCounter("PollCount", 1),
+		metrics.NewMetricGauge("RandomValue", metrics.Gauge(rand.Float64()*math.MaxFloat64)),
+	}, nil
+}
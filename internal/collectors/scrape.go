@@ -0,0 +1,343 @@
+package collectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/telemetry"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scrapeName = "scrape"
+
+	defaultScrapeTimeout = 5 * time.Second
+	// maxScrapeSize - limit of scraped body, larger endpoint is most likely misconfigured.
+	maxScrapeSize = 16 << 20
+)
+
+// Formats of scraped endpoints.
+const (
+	FormatPrometheus = "prometheus"
+	FormatExpvar     = "expvar"
+)
+
+// ScrapeTarget - endpoint scraped by agent. Its metrics are named "<Name>_<metric>", prometheus labels
+// are appended to metric name as "_<label>_<value>" in order of exposition. Names longer than server accepts
+// are truncated and end with hash of full name.
+type ScrapeTarget struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Format  string `json:"format"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ScrapeOptions - options of scrape collector.
+type ScrapeOptions struct {
+	Targets []ScrapeTarget `json:"targets"`
+}
+
+type scrapeTarget struct {
+	ScrapeTarget
+	timeout time.Duration
+}
+
+// Scrape - pulls metrics from prometheus text format and expvar json endpoints.
+// Prometheus counters are sent as increments of their integer part since previous scrape,
+// other prometheus samples and every number of expvar are sent as gauges.
+type Scrape struct {
+	targets []scrapeTarget
+	client  *http.Client
+	deltas  *deltas
+}
+
+// NewScrape - creates scrape collector, at least one target must be configured.
+func NewScrape(options json.RawMessage) (Collector, error) {
+	var opts ScrapeOptions
+	if len(options) > 0 {
+		err := json.Unmarshal(options, &opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.Targets) == 0 {
+		return nil, errors.New("no scrape targets configured")
+	}
+
+	s := &Scrape{client: &http.Client{}, deltas: newDeltas()}
+	names := make(map[string]struct{}, len(opts.Targets))
+	for _, t := range opts.Targets {
+		if t.Name == "" || t.URL == "" {
+			return nil, errors.New("scrape target name and url are required")
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("scrape target %q is configured twice", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		// Agent rejects collected batches with its telemetry names, so such target would lose all its metrics
+		if strings.HasPrefix(sanitize(t.Name)+"_", telemetry.Prefix) {
+			return nil, fmt.Errorf("scrape target %q: names with prefix %q are reserved for agent", t.Name, telemetry.Prefix)
+		}
+
+		if t.Format != FormatPrometheus && t.Format != FormatExpvar {
+			return nil, fmt.Errorf("scrape target %q: unsupported format %q", t.Name, t.Format)
+		}
+
+		timeout := defaultScrapeTimeout
+		if t.Timeout != "" {
+			var err error
+			timeout, err = time.ParseDuration(t.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("scrape target %q: %w", t.Name, err)
+			}
+		}
+
+		s.targets = append(s.targets, scrapeTarget{ScrapeTarget: t, timeout: timeout})
+	}
+
+	return s, nil
+}
+
+func (s *Scrape) Name() string {
+	return scrapeName
+}
+
+// Collect - scrapes every target. Failed target is logged and skipped, error is returned only if every target failed.
+func (s *Scrape) Collect(ctx context.Context) ([]metrics.Metric, error) {
+	var (
+		result  []metrics.Metric
+		lastErr error
+	)
+	for _, t := range s.targets {
+		mtrcs, err := s.scrape(ctx, t)
+		if err != nil {
+			log.Println(scrapeName, t.Name, err)
+			lastErr = err
+			continue
+		}
+		result = append(result, mtrcs...)
+	}
+
+	if result == nil && lastErr != nil {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+func (s *Scrape) scrape(ctx context.Context, t scrapeTarget) ([]metrics.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.Format == FormatPrometheus {
+		req.Header.Set("Accept", "text/plain;version=0.0.4")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded %d", t.URL, resp.StatusCode)
+	}
+
+	body := io.LimitReader(resp.Body, maxScrapeSize)
+	if t.Format == FormatExpvar {
+		return parseExpvar(sanitize(t.Name), body)
+	}
+
+	samples, err := parsePrometheus(body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]metrics.Metric, 0, len(samples))
+	for _, sample := range samples {
+		name := capName(sanitize(t.Name) + "_" + sample.name)
+		if !sample.counter {
+			result = append(result, metrics.NewMetricGauge(name, metrics.Gauge(sample.value)))
+			continue
+		}
+		if sample.value < 0 {
+			continue
+		}
+		if metric, ok := s.deltas.counter(name, uint64(sample.value)); ok {
+			result = append(result, metric)
+		}
+	}
+	return result, nil
+}
+
+// promSample - sample of prometheus text format, name includes sanitized labels.
+type promSample struct {
+	name    string
+	value   float64
+	counter bool
+}
+
+// parsePrometheus - parses prometheus text exposition format. Samples of counters, and bucket and count samples
+// of histograms and summaries are marked as counters. Non finite values are skipped.
+func parsePrometheus(r io.Reader) ([]promSample, error) {
+	types := make(map[string]string)
+	var samples []promSample
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) == 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, labels, rest, err := splitSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: no value", lineNum)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		full := sanitize(name)
+		for _, label := range labels {
+			full += "_" + sanitize(label[0]) + "_" + sanitize(label[1])
+		}
+		samples = append(samples, promSample{name: full, value: value, counter: isPromCounter(types, name)})
+	}
+
+	return samples, scanner.Err()
+}
+
+func isPromCounter(types map[string]string, name string) bool {
+	if types[name] == "counter" {
+		return true
+	}
+
+	for _, suffix := range []string{"_bucket", "_count"} {
+		family := strings.TrimSuffix(name, suffix)
+		if family != name && (types[family] == "histogram" || types[family] == "summary") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSample - splits sample line to metric name, labels and the rest with value and timestamp.
+func splitSample(line string) (string, [][2]string, string, error) {
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return "", nil, "", errors.New("invalid sample")
+	}
+	name := line[:end]
+	if line[end] != '{' {
+		return name, nil, line[end:], nil
+	}
+
+	var labels [][2]string
+	i := end + 1
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == ',') {
+			i++
+		}
+		if i >= len(line) {
+			return "", nil, "", errors.New("unterminated labels")
+		}
+		if line[i] == '}' {
+			return name, labels, line[i+1:], nil
+		}
+
+		eq := strings.IndexByte(line[i:], '=')
+		if eq <= 0 || i+eq+1 >= len(line) || line[i+eq+1] != '"' {
+			return "", nil, "", errors.New("invalid label")
+		}
+		key := strings.TrimSpace(line[i : i+eq])
+		i += eq + 2
+
+		var value strings.Builder
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+				if line[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(line[i])
+		}
+		if i >= len(line) {
+			return "", nil, "", errors.New("unterminated label value")
+		}
+		i++
+
+		labels = append(labels, [2]string{key, value.String()})
+	}
+}
+
+// parseExpvar - flattens expvar json into gauges named by path of keys, non numeric values are skipped.
+func parseExpvar(prefix string, r io.Reader) ([]metrics.Metric, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var vars map[string]interface{}
+	err := decoder.Decode(&vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.Metric
+	flattenExpvar(prefix, vars, &result)
+	return result, nil
+}
+
+func flattenExpvar(prefix string, vars map[string]interface{}, result *[]metrics.Metric) {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := prefix + "_" + sanitize(key)
+		switch value := vars[key].(type) {
+		case json.Number:
+			f, err := value.Float64()
+			if err == nil {
+				*result = append(*result, metrics.NewMetricGauge(capName(name), metrics.Gauge(f)))
+			}
+		case map[string]interface{}:
+			flattenExpvar(name, value, result)
+		}
+	}
+}
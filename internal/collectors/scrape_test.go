@@ -0,0 +1,138 @@
+package collectors
+
+import (
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParsePrometheus(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		want      []promSample
+		wantError bool
+	}{
+		{
+			name: "Typed samples",
+			text: `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+# TYPE temperature gauge
+temperature -3.5
+untyped_value 7
+`,
+			want: []promSample{
+				{name: "http_requests_total_method_post_code_200", value: 1027, counter: true},
+				{name: "temperature", value: -3.5},
+				{name: "untyped_value", value: 7},
+			},
+		},
+		{
+			name: "Histogram",
+			text: `# TYPE latency histogram
+latency_bucket{le="0.5"} 3
+latency_bucket{le="+Inf"} 4
+latency_sum 1.5
+latency_count 4
+`,
+			want: []promSample{
+				{name: "latency_bucket_le_0.5", value: 3, counter: true},
+				{name: "latency_bucket_le__Inf", value: 4, counter: true},
+				{name: "latency_sum", value: 1.5},
+				{name: "latency_count", value: 4, counter: true},
+			},
+		},
+		{
+			name: "Escaped label and non finite value",
+			text: `msg{text="a \"b\", c"} 1
+nan_value NaN
+`,
+			want: []promSample{{name: "msg_text_a__b___c", value: 1}},
+		},
+		{name: "Unterminated labels", text: `broken{a="b" 1`, wantError: true},
+		{name: "Invalid value", text: `broken one`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := parsePrometheus(strings.NewReader(tt.text))
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, samples)
+		})
+	}
+}
+
+func TestCapName(t *testing.T) {
+	long := strings.Repeat("label_value_", 30)
+
+	assert.Equal(t, "short", capName("short"))
+	assert.Len(t, capName(long+"a"), wire.MaxNameLength)
+	assert.NotEqual(t, capName(long+"a"), capName(long+"b"), "truncated names must stay distinct")
+	assert.Equal(t, capName(long+"a"), capName(long+"a"))
+}
+
+func TestParseExpvar(t *testing.T) {
+	mtrcs, err := parseExpvar("app", strings.NewReader(`{"cmdline": ["app"], "requests": 10, "memstats": {"Alloc": 2048, "PauseNs": [1, 2], "EnableGC": true}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []metrics.Metric{
+		metrics.NewMetricGauge("app_memstats_Alloc", 2048),
+		metrics.NewMetricGauge("app_requests", 10),
+	}, mtrcs)
+}
+
+func TestScrape_Collect(t *testing.T) {
+	requests := 0
+	prometheus := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		rw.Write([]byte("# TYPE jobs_total counter\njobs_total " + strings.Repeat("1", requests) + "\nqueue 5\n"))
+	}))
+	defer prometheus.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	c, err := NewScrape(json.RawMessage(`{"targets": [
+		{"name": "worker", "url": "` + prometheus.URL + `", "format": "prometheus"},
+		{"name": "down", "url": "` + down.URL + `", "format": "expvar"}
+	]}`))
+	require.NoError(t, err)
+
+	got := collectByName(t, c)
+	assert.Equal(t, metrics.Gauge(5), got["worker_queue"].GetGaugeValue())
+	assert.NotContains(t, got, "worker_jobs_total", "counter must be reported since the second scrape")
+
+	got = collectByName(t, c)
+	assert.Equal(t, metrics.Counter(10), got["worker_jobs_total"].GetCounterValue())
+}
+
+func TestNewScrape(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+	}{
+		{name: "No targets", options: `{}`},
+		{name: "No url", options: `{"targets": [{"name": "a", "format": "expvar"}]}`},
+		{name: "Unsupported format", options: `{"targets": [{"name": "a", "url": "http://localhost", "format": "xml"}]}`},
+		{name: "Reserved name", options: `{"targets": [{"name": "agent", "url": "http://localhost", "format": "expvar"}]}`},
+		{name: "Invalid timeout", options: `{"targets": [{"name": "a", "url": "http://localhost", "format": "expvar", "timeout": "soon"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScrape(json.RawMessage(tt.options))
+			assert.Error(t, err)
+		})
+	}
+}
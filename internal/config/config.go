@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/collectors"
 	"os"
 )
 
@@ -11,9 +12,30 @@ type ServerConfig struct {
 	StoreFile     string `json:"store_file,omitempty"`
 	Restore       bool   `json:"restore,omitempty"`
 	Key           string `json:"key,omitempty"`
+	KeyID         string `json:"key_id,omitempty"`
+	Keyring       string `json:"keyring,omitempty"`
+	SignStrict    bool   `json:"sign_strict,omitempty"`
 	Dsn           string `json:"dsn,omitempty"`
 	Crypt         string `json:"crypt,omitempty"`
 	Subnet        string `json:"subnet,omitempty"`
+	DeniedSubnet  string `json:"denied_subnet,omitempty"`
+	Proxies       string `json:"trusted_proxies,omitempty"`
+	GRPCAddress   string `json:"grpc_address,omitempty"`
+	TLSCert       string `json:"tls_cert,omitempty"`
+	TLSKey        string `json:"tls_key,omitempty"`
+	TLSClientCA   string `json:"tls_client_ca,omitempty"`
+	Tokens        string `json:"tokens,omitempty"`
+	MaxBodySize   int    `json:"max_body_size,omitempty"`
+	MaxBatch      int    `json:"max_batch,omitempty"`
+	MaxSeries     int    `json:"max_series,omitempty"`
+	Rate          int    `json:"ingest_rate,omitempty"`
+	Burst         int    `json:"ingest_burst,omitempty"`
+	GlobalRate    int    `json:"ingest_global_rate,omitempty"`
+	GlobalBurst   int    `json:"ingest_global_burst,omitempty"`
+	AuditFile     string `json:"audit_file,omitempty"`
+	AuditMaxSize  int    `json:"audit_max_size,omitempty"`
+	AuditBackups  int    `json:"audit_backups,omitempty"`
+	AuditDB       bool   `json:"audit_db,omitempty"`
 }
 
 const filename = "config.json"
@@ -35,12 +57,24 @@ func NewServerConfig() (*ServerConfig, error) {
 }
 
 type AgentConfig struct {
-	Address        string `json:"address,omitempty"`
-	PollInterval   string `json:"poll_interval,omitempty"`
-	ReportInterval string `json:"report_interval,omitempty"`
-	Key            string `json:"key,omitempty"`
-	Limit          int    `json:"limit,omitempty"`
-	Crypto         string `json:"crypto,omitempty"`
+	Address        string                         `json:"address,omitempty"`
+	PollInterval   string                         `json:"poll_interval,omitempty"`
+	ReportInterval string                         `json:"report_interval,omitempty"`
+	Key            string                         `json:"key,omitempty"`
+	KeyID          string                         `json:"key_id,omitempty"`
+	Keyring        string                         `json:"keyring,omitempty"`
+	HashAlgorithm  string                         `json:"hash_algorithm,omitempty"`
+	Limit          int                            `json:"limit,omitempty"`
+	Crypto         string                         `json:"crypto,omitempty"`
+	TLSCA          string                         `json:"tls_ca,omitempty"`
+	TLSCert        string                         `json:"tls_cert,omitempty"`
+	TLSKey         string                         `json:"tls_key,omitempty"`
+	Token          string                         `json:"token,omitempty"`
+	AgentID        string                         `json:"agent_id,omitempty"`
+	Collectors     map[string]collectors.Settings `json:"collectors,omitempty"`
+	LocalHTTP      string                         `json:"local_http,omitempty"`
+	LocalUDP       string                         `json:"local_udp,omitempty"`
+	Telemetry      string                         `json:"telemetry_address,omitempty"`
 }
 
 func NewAgentConfig() (*AgentConfig, error) {
@@ -2,17 +2,37 @@ package crypt
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
 	"io"
 	"log"
 	"net/http"
 	"os"
 )
 
+const (
+	// SchemeHeader - header that declares encryption scheme of request body.
+	SchemeHeader = "X-Encryption"
+	// Scheme - body is AES-256-GCM ciphertext with a random per-request key,
+	// the key is wrapped with RSA-OAEP(SHA-256) and prepended to the body:
+	// wrapped key (size of RSA modulus) | GCM nonce | ciphertext with tag.
+	Scheme = "rsa-oaep-aes256gcm"
+
+	aesKeySize = 32
+)
+
+var (
+	ErrUnencrypted = errors.New("request body is not encrypted")
+	ErrMalformed   = errors.New("malformed encrypted body")
+)
+
 type Crypter interface {
 	Encrypt(data []byte) ([]byte, error)
 	GetDecryptMiddleware() func(next http.Handler) http.Handler
@@ -45,6 +65,9 @@ func WithPrivateKey(keyPath string) func(c *crypt) error {
 		}
 
 		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.New("no PEM data in private key file")
+		}
 
 		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 		if err != nil {
@@ -66,6 +89,9 @@ func WithPublicKey(keyPath string) func(c *crypt) error {
 		}
 
 		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.New("no PEM data in public key file")
+		}
 
 		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
 		if err != nil {
@@ -78,28 +104,113 @@ func WithPublicKey(keyPath string) func(c *crypt) error {
 	}
 }
 
+// Encrypt - encrypts data of any size with Scheme.
 func (c *crypt) Encrypt(data []byte) ([]byte, error) {
-	return rsa.EncryptOAEP(sha256.New(), rand.Reader, c.publicKey, data, []byte(""))
+	if c.publicKey == nil {
+		return nil, errors.New("public key is not provided")
+	}
+
+	key := make([]byte, aesKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, c.publicKey, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, len(wrappedKey)+len(nonce)+len(data)+gcm.Overhead())
+	result = append(result, wrappedKey...)
+	result = append(result, nonce...)
+	return gcm.Seal(result, nonce, data, nil), nil
+}
+
+func (c *crypt) decrypt(data []byte) ([]byte, error) {
+	if c.privateKey == nil {
+		return nil, errors.New("private key is not provided")
+	}
+
+	keySize := c.privateKey.Size()
+	if len(data) < keySize {
+		return nil, ErrMalformed
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, c.privateKey, data[:keySize], nil)
+	if err != nil || len(key) != aesKeySize {
+		return nil, ErrMalformed
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[keySize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return plain, nil
 }
 
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// GetDecryptMiddleware - returns middleware that decrypts request bodies encrypted with Scheme.
+// Requests without body pass through, unencrypted bodies are rejected.
 func (c *crypt) GetDecryptMiddleware() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(rw http.ResponseWriter, r *http.Request) {
 				body, err := io.ReadAll(r.Body)
 				if err != nil {
-					log.Println(err)
-					rw.WriteHeader(http.StatusInternalServerError)
+					apierror.WriteBodyError(rw, r, err)
+					return
+				}
+
+				if len(body) == 0 {
+					r.Body = io.NopCloser(bytes.NewReader(body))
+					next.ServeHTTP(rw, r)
+					return
+				}
+
+				if r.Header.Get(SchemeHeader) != Scheme {
+					apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeEncryptionRequired, ErrUnencrypted.Error())
 					return
 				}
 
-				decrypted, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, c.privateKey, body, []byte(""))
+				decrypted, err := c.decrypt(body)
 				if err != nil {
 					log.Println(err)
-					rw.WriteHeader(http.StatusInternalServerError)
+					apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeDecryptionFailed, err.Error())
 					return
 				}
 
+				r.Header.Del(SchemeHeader)
 				r.Body = io.NopCloser(bytes.NewReader(decrypted))
 				r.ContentLength = int64(len(decrypted))
 
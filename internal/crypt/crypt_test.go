@@ -0,0 +1,100 @@
+package crypt
+
+import (
+	"bytes"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecryptMiddleware(t *testing.T) {
+	privatePath, publicPath := testutil.WriteKeys(t)
+
+	encrypter, err := New(WithPublicKey(publicPath))
+	require.NoError(t, err)
+	decrypter, err := New(WithPrivateKey(privatePath))
+	require.NoError(t, err)
+
+	// Batch far bigger than RSA-OAEP can hold directly.
+	batch := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":123456.789},`), 20000)
+
+	encrypted, err := encrypter.Encrypt(batch)
+	require.NoError(t, err)
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	var received []byte
+	handler := decrypter.GetDecryptMiddleware()(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+
+	tests := []struct {
+		name       string
+		body       []byte
+		scheme     string
+		statusCode int
+		want       []byte
+	}{
+		{
+			name:       "Encrypted batch",
+			body:       encrypted,
+			scheme:     Scheme,
+			statusCode: http.StatusOK,
+			want:       batch,
+		},
+		{
+			name:       "Unencrypted body",
+			body:       batch,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Unencrypted body with scheme header",
+			body:       batch,
+			scheme:     Scheme,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Tampered body",
+			body:       tampered,
+			scheme:     Scheme,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Empty body",
+			statusCode: http.StatusOK,
+			want:       []byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				request.Header.Set(SchemeHeader, tt.scheme)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.statusCode, result.StatusCode)
+			assert.Equal(t, tt.want, received)
+		})
+	}
+}
+
+func TestEncrypt_WithoutPublicKey(t *testing.T) {
+	c, err := New()
+	require.NoError(t, err)
+
+	_, err = c.Encrypt([]byte("data"))
+	require.Error(t, err)
+}
@@ -0,0 +1,151 @@
+// Package gateway - local ingestion of agent. Metrics pushed by scripts on the host are merged into agent's
+// storage and forwarded to server with the next report, so scripts need neither server credentials nor keys.
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/handlers"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/middleware"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBodySize - limit of http request body, local pushes are expected to be small.
+	maxBodySize = 1 << 20
+	// maxDatagramSize - the largest UDP payload.
+	maxDatagramSize = 64 << 10
+
+	updatePrefix = "/update/"
+)
+
+type metricRepository interface {
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
+}
+
+// NewHandler - creates handler accepting updates in formats of server:
+// "/update/{kind}/{name}/{value}", json metric to "/update/" and json array of metrics to "/updates/".
+// Requests aren't authenticated and metrics aren't hashed, so handler must listen on local address only.
+func NewHandler(storage metricRepository) http.Handler {
+	router := chi.NewRouter()
+	router.Use(middleware.Decompress, middleware.MaxBytes(maxBodySize))
+
+	router.Route("/update", func(r chi.Router) {
+		r.Post("/", handlers.JSONUpdateHandler(storage, nil))
+		r.Post("/{kind}/{name}/{value}", handlers.UpdateStorageHandler(storage, nil))
+	})
+	router.Post("/updates/", handlers.MetricsUpdateHandler(storage, nil))
+
+	return router
+}
+
+// ServeUDP - reads datagrams till conn is closed. Datagram is json metric, json array of metrics
+// or "/update/{kind}/{name}/{value}" lines, "/update/" prefix may be omitted. Malformed datagrams are logged and dropped.
+func ServeUDP(conn net.PacketConn, storage metricRepository) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mtrcs, err := ParseDatagram(buf[:n])
+		if err != nil {
+			log.Println("udp datagram from", addr, err)
+			continue
+		}
+
+		err = storage.BatchUpdate(context.Background(), mtrcs)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// ParseDatagram - parses metrics of UDP datagram.
+func ParseDatagram(data []byte) ([]metrics.Metric, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty datagram")
+	}
+
+	var jsonMetrics []handlers.JSONMetric
+	switch data[0] {
+	case '[':
+		err := json.Unmarshal(data, &jsonMetrics)
+		if err != nil {
+			return nil, err
+		}
+	case '{':
+		var jsonMetric handlers.JSONMetric
+		err := json.Unmarshal(data, &jsonMetric)
+		if err != nil {
+			return nil, err
+		}
+		jsonMetrics = append(jsonMetrics, jsonMetric)
+	default:
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			jsonMetric, err := parseLine(line)
+			if err != nil {
+				return nil, err
+			}
+			jsonMetrics = append(jsonMetrics, jsonMetric)
+		}
+	}
+
+	result := make([]metrics.Metric, 0, len(jsonMetrics))
+	for _, jm := range jsonMetrics {
+		metric, err := jm.ToMetric()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, metric)
+	}
+	return result, nil
+}
+
+// parseLine - parses "{kind}/{name}/{value}" line like path of update request.
+func parseLine(line string) (handlers.JSONMetric, error) {
+	line = strings.TrimPrefix(strings.TrimPrefix(line, updatePrefix), "/")
+	parts := strings.Split(line, "/")
+	if len(parts) != 3 {
+		return handlers.JSONMetric{}, fmt.Errorf("line %q: expected {kind}/{name}/{value}", line)
+	}
+
+	jm := handlers.JSONMetric{ID: parts[1], MType: parts[0]}
+	switch jm.MType {
+	case "gauge":
+		value, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return handlers.JSONMetric{}, fmt.Errorf("line %q: gauge value must be a float", line)
+		}
+		jm.Value = &value
+	case "counter":
+		delta, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return handlers.JSONMetric{}, fmt.Errorf("line %q: counter value must be an integer", line)
+		}
+		jm.Delta = &delta
+	}
+	return jm, nil
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHandler(t *testing.T) {
+	storage := repository.NewMemStorage()
+	handler := NewHandler(storage)
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		statusCode int
+	}{
+		{name: "Path update", target: "/update/counter/Jobs/2", statusCode: http.StatusOK},
+		{name: "Json update", target: "/update/", body: `{"id":"Jobs","type":"counter","delta":3}`, statusCode: http.StatusOK},
+		{name: "Json batch", target: "/updates/", body: `[{"id":"Duration","type":"gauge","value":1.5}]`, statusCode: http.StatusOK},
+		{name: "Invalid value", target: "/update/gauge/Duration/fast", statusCode: http.StatusBadRequest},
+		{name: "Reading isn't served", target: "/value/", body: `{"id":"Jobs","type":"counter"}`, statusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			assert.Equal(t, tt.statusCode, recorder.Code)
+		})
+	}
+
+	got, err := storage.GetMetricsMap(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]metrics.Metric{
+		"Jobs":     metrics.NewMetricCounter("Jobs", 5),
+		"Duration": metrics.NewMetricGauge("Duration", 1.5),
+	}, got)
+}
+
+func TestParseDatagram(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      []metrics.Metric
+		wantError bool
+	}{
+		{
+			name: "Lines",
+			data: "/update/counter/Jobs/1\ngauge/Duration/0.5\n",
+			want: []metrics.Metric{metrics.NewMetricCounter("Jobs", 1), metrics.NewMetricGauge("Duration", 0.5)},
+		},
+		{
+			name: "Json metric",
+			data: `{"id":"Jobs","type":"counter","delta":2}`,
+			want: []metrics.Metric{metrics.NewMetricCounter("Jobs", 2)},
+		},
+		{
+			name: "Json array",
+			data: `[{"id":"Duration","type":"gauge","value":3}]`,
+			want: []metrics.Metric{metrics.NewMetricGauge("Duration", 3)},
+		},
+		{name: "Empty", data: " \n", wantError: true},
+		{name: "Missing value", data: "counter/Jobs", wantError: true},
+		{name: "Fractional counter", data: "counter/Jobs/1.5", wantError: true},
+		{name: "Unsupported kind", data: "histogram/Jobs/1", wantError: true},
+		{name: "Gauge without value", data: `{"id":"Duration","type":"gauge"}`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDatagram([]byte(tt.data))
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServeUDP(t *testing.T) {
+	storage := repository.NewMemStorage()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- ServeUDP(conn, storage)
+	}()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer sender.Close()
+	for _, datagram := range []string{"counter/Jobs/1", "broken", `{"id":"Jobs","type":"counter","delta":4}`} {
+		_, err = sender.Write([]byte(datagram))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		metric, err := storage.GetMetric(context.Background(), "Jobs")
+		return err == nil && metric.GetCounterValue() == 5
+	}, time.Second, 10*time.Millisecond, "valid datagrams must be merged, malformed dropped")
+
+	require.NoError(t, conn.Close())
+	assert.NoError(t, <-done, "closed connection must stop serving")
+}
@@ -0,0 +1,42 @@
+package grpcserver
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/handlers"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/proto"
+	"google.golang.org/grpc"
+)
+
+type metricRepository interface {
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
+}
+
+// MethodScopes - token scopes required by methods of MetricsCollection service.
+var MethodScopes = map[string]auth.Scope{
+	proto.MetricsCollection_UpdateMetrics_FullMethodName: auth.ScopeIngest,
+}
+
+// metricsServer - implementation of MetricsCollection service.
+type metricsServer struct {
+	proto.UnimplementedMetricsCollectionServer
+	update func(ctx context.Context, request *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error)
+}
+
+func (s *metricsServer) UpdateMetrics(ctx context.Context, in *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error) {
+	return s.update(ctx, in)
+}
+
+// New - creates gRPC server with registered MetricsCollection service.
+func New(storage metricRepository, keys *hash.Keyring, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	proto.RegisterMetricsCollectionServer(server, &metricsServer{
+		update: handlers.GRPCMetricUpdateHandler(storage, keys),
+	})
+	return server
+}
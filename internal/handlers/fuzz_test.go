@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	pb "github.com/VladimirMovsesyan/praktikum-devops/proto"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// checkErrorBody - asserts that every 4xx response carries structured error body.
+func checkErrorBody(t *testing.T, result *http.Response) {
+	if result.StatusCode < http.StatusBadRequest {
+		return
+	}
+
+	var body apierror.Response
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&body))
+	assert.NotEmpty(t, body.Code)
+	assert.NotEmpty(t, body.Message)
+}
+
+func FuzzJSONUpdateHandler(f *testing.F) {
+	f.Add([]byte(`{"id":"test","type":"gauge","value":1.5}`))
+	f.Add([]byte(`{"id":"test","type":"counter","delta":3}`))
+	f.Add([]byte(`{"id":"x","type":"foo"}`))
+	f.Add([]byte(`{"id":"test","type":"gauge"}`))
+	f.Add([]byte(`{"id":"test","type":"counter"}`))
+	f.Add([]byte(`{"id":"","type":"counter","delta":1}`))
+	f.Add([]byte(`{"id":"test","type":"gauge","value":1e400}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`[`))
+
+	router := chi.NewRouter()
+	router.Post("/update/", JSONUpdateHandler(repository.NewMemStorage(), nil))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, request)
+		result := recorder.Result()
+		defer result.Body.Close()
+
+		require.Contains(t, []int{http.StatusOK, http.StatusBadRequest}, result.StatusCode)
+		checkErrorBody(t, result)
+	})
+}
+
+func FuzzMetricsUpdateHandler(f *testing.F) {
+	f.Add([]byte(`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"counter","delta":2}]`))
+	f.Add([]byte(`[{"id":"x","type":"foo"}]`))
+	f.Add([]byte(`[{"id":"a","type":"gauge"}]`))
+	f.Add([]byte(`[null]`))
+	f.Add([]byte(`[]`))
+	f.Add([]byte(`{}`))
+
+	router := chi.NewRouter()
+	router.Post("/updates/", MetricsUpdateHandler(repository.NewMemStorage(), nil))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, request)
+		result := recorder.Result()
+		defer result.Body.Close()
+
+		require.Contains(t, []int{http.StatusOK, http.StatusBadRequest}, result.StatusCode)
+		checkErrorBody(t, result)
+	})
+}
+
+func FuzzUpdateStorageHandler(f *testing.F) {
+	f.Add("gauge", "test", "1.5")
+	f.Add("counter", "test", "3")
+	f.Add("counter", "test", "99999999999999999999")
+	f.Add("gauge", "test", "NaN")
+	f.Add("gauge", "test", "-Inf")
+	f.Add("foo", "test", "1")
+	f.Add("gauge", "", "1")
+
+	router := chi.NewRouter()
+	router.Post("/update/{kind}/{name}/{value}", UpdateStorageHandler(repository.NewMemStorage(), nil))
+
+	f.Fuzz(func(t *testing.T, kind, name, value string) {
+		target := "/update/" + url.PathEscape(kind) + "/" + url.PathEscape(name) + "/" + url.PathEscape(value)
+		request, err := http.NewRequest(http.MethodPost, target, nil)
+		if err != nil {
+			t.Skip()
+		}
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, request)
+		result := recorder.Result()
+		defer result.Body.Close()
+
+		require.Contains(
+			t,
+			[]int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound},
+			result.StatusCode,
+		)
+	})
+}
+
+func FuzzGRPCMetricUpdateHandler(f *testing.F) {
+	seeds := []*pb.BatchUpdateMetricsRequest{
+		{Metrics: []*pb.Metrics{{ID: "a", MType: pb.Metrics_GAUGE, Value: 1.5}}},
+		{Metrics: []*pb.Metrics{{ID: "b", MType: pb.Metrics_COUNTER, Delta: 3}}},
+		{Metrics: []*pb.Metrics{{ID: "c", MType: pb.Metrics_UNKNOWN}}},
+		{Metrics: []*pb.Metrics{{MType: pb.Metrics_GAUGE}}},
+		{Metrics: []*pb.Metrics{nil}},
+	}
+	for _, seed := range seeds {
+		data, err := proto.Marshal(seed)
+		require.NoError(f, err)
+		f.Add(data)
+	}
+
+	handler := GRPCMetricUpdateHandler(repository.NewMemStorage(), nil)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var request pb.BatchUpdateMetricsRequest
+		if proto.Unmarshal(data, &request) != nil {
+			return
+		}
+
+		_, err := handler(context.Background(), &request)
+		if err != nil {
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+		}
+	})
+}
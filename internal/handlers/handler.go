@@ -6,11 +6,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
 	"github.com/VladimirMovsesyan/praktikum-devops/proto"
 	"github.com/go-chi/chi/v5"
 	_ "github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"io"
 	"log"
 	"net/http"
@@ -18,21 +24,32 @@ import (
 )
 
 type metricRepository interface {
-	GetMetricsMap() map[string]metrics.Metric
-	GetMetric(name string) (metrics.Metric, error)
-	Update(metrics.Metric)
-	BatchUpdate(metrics []metrics.Metric)
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
 }
 
-const (
-	hashGaugeFormat   = "%s:%s:%f"
-	hashCounterFormat = "%s:%s:%d"
-)
+// writeUpdateError - writes error of storage update. Exceeded limits of repository.LimitedStorage,
+// reserved names of repository.ReservedStorage and kind changes are client errors, anything else is reported as unavailable storage.
+func writeUpdateError(rw http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrBatchTooLarge):
+		apierror.Write(rw, r, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, err.Error())
+	case errors.Is(err, repository.ErrSeriesLimit):
+		apierror.Write(rw, r, http.StatusUnprocessableEntity, apierror.CodeSeriesLimit, err.Error())
+	case errors.Is(err, repository.ErrReservedName), errors.Is(err, repository.ErrKindMismatch):
+		apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidMetric, err.Error())
+	default:
+		log.Println(err)
+		apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeStorageUnavailable, message)
+	}
+}
 
 // UpdateStorageHandler - handler that routing from "/update/kind/name/value".
 // Parsing query params to values and updating metric in DB.
 // If metric with such name and kind doesn't exist, creating new metric.
-func UpdateStorageHandler(storage metricRepository, key string) http.HandlerFunc {
+func UpdateStorageHandler(storage metricRepository, keys *hash.Keyring) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		kind := chi.URLParam(r, "kind")
 		name := chi.URLParam(r, "name")
@@ -41,7 +58,7 @@ func UpdateStorageHandler(storage metricRepository, key string) http.HandlerFunc
 		var newMetric metrics.Metric
 
 		if value == "" {
-			rw.WriteHeader(http.StatusNotFound)
+			apierror.Write(rw, r, http.StatusNotFound, apierror.CodeNotFound, "empty metric value")
 			return
 		}
 
@@ -49,52 +66,49 @@ func UpdateStorageHandler(storage metricRepository, key string) http.HandlerFunc
 		case "gauge":
 			value, err := strconv.ParseFloat(value, 64)
 			if err != nil {
-				log.Println(err)
-				rw.WriteHeader(http.StatusBadRequest)
+				apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidMetric, "gauge value must be a float")
 				return
 			}
 			newMetric = metrics.NewMetricGauge(name, metrics.Gauge(value))
 		case "counter":
-			value, err := strconv.Atoi(value)
+			value, err := strconv.ParseInt(value, 10, 64)
 			if err != nil {
-				log.Println(err)
-				rw.WriteHeader(http.StatusBadRequest)
+				apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidMetric, "counter value must be an integer")
 				return
 			}
 			newMetric = metrics.NewMetricCounter(name, metrics.Counter(value))
 		default:
-			rw.WriteHeader(http.StatusNotImplemented)
+			err := unsupportedType(name, kind)
+			apierror.Write(rw, r, http.StatusBadRequest, metricErrorCode(err), err.Error())
 			return
 		}
 
-		hashData, err := getHashData(newMetric)
+		err := validateMetric(newMetric)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotImplemented)
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidMetric, err.Error())
 			return
 		}
 
-		hashHeader := r.Header.Get("Hash")
-		if key != "" {
-			if !hash.Valid(hashHeader, hashData, key) {
-				rw.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			rw.Header().Set("Hash", hash.Get(hashData, key))
+		alg := r.Header.Get(hash.AlgorithmHeader)
+		err = checkHash(r.Context(), keys, newMetric, alg, r.Header.Get("Hash"), r.Header.Get(hash.KeyIDHeader))
+		if err != nil {
+			apierror.Write(rw, r, http.StatusBadRequest, hashErrorCode(err), err.Error())
+			return
+		}
+		signResponse(rw, keys, newMetric, alg)
+
+		err = storage.Update(r.Context(), newMetric)
+		if err != nil {
+			writeUpdateError(rw, r, err, "couldn't update metric")
+			return
 		}
 
-		storage.Update(newMetric)
 		rw.WriteHeader(http.StatusOK)
 	}
 }
 
 // JSONMetric - struct that helps to marshal/unmarshal metric to/from json representation.
-type JSONMetric struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // значение хеш-функции
-}
+type JSONMetric wire.Metric
 
 func NewJSONMetric(metric metrics.Metric) (*JSONMetric, error) {
 	jsonMetric := &JSONMetric{
@@ -119,12 +133,11 @@ func NewJSONMetric(metric metrics.Metric) (*JSONMetric, error) {
 // JSONUpdateHandler - handler that routing from "/update".
 // Parsing json provided data to values and updating metric in DB.
 // If metric with such name and kind doesn't exist, creating new metric.
-func JSONUpdateHandler(storage metricRepository, key string) http.HandlerFunc {
+func JSONUpdateHandler(storage metricRepository, keys *hash.Keyring) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		bytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Println(err.Error())
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.WriteBodyError(rw, r, err)
 			return
 		}
 
@@ -133,45 +146,29 @@ func JSONUpdateHandler(storage metricRepository, key string) http.HandlerFunc {
 
 		err = json.Unmarshal(bytes, &jsonMetric)
 		if err != nil {
-			log.Println(err.Error())
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 			return
 		}
 
-		var metric metrics.Metric
-
-		switch jsonMetric.MType {
-		case "gauge":
-			metric = metrics.NewMetricGauge(
-				jsonMetric.ID,
-				metrics.Gauge(*jsonMetric.Value),
-			)
-		case "counter":
-			metric = metrics.NewMetricCounter(
-				jsonMetric.ID,
-				metrics.Counter(*jsonMetric.Delta),
-			)
-		default:
-			log.Fatal("Not implemented")
+		metric, err := jsonMetric.ToMetric()
+		if err != nil {
+			apierror.Write(rw, r, http.StatusBadRequest, metricErrorCode(err), err.Error())
+			return
 		}
 
-		hashData, err := getHashData(metric)
+		err = checkHash(r.Context(), keys, metric, jsonMetric.HashAlgorithm, jsonMetric.Hash, jsonMetric.KeyID)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotImplemented)
+			apierror.Write(rw, r, http.StatusBadRequest, hashErrorCode(err), err.Error())
 			return
 		}
+		signResponse(rw, keys, metric, jsonMetric.HashAlgorithm)
 
-		hashHeader := jsonMetric.Hash
-		if key != "" {
-			if !hash.Valid(hashHeader, hashData, key) {
-				rw.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			rw.Header().Set("Hash", hash.Get(hashData, key))
+		err = storage.Update(r.Context(), metric)
+		if err != nil {
+			writeUpdateError(rw, r, err, "couldn't update metric")
+			return
 		}
 
-		storage.Update(metric)
-
 		rw.Header().Add("Content-Type", "application/json")
 		_, err = rw.Write(bytes)
 		if err != nil {
@@ -187,11 +184,12 @@ func JSONUpdateHandler(storage metricRepository, key string) http.HandlerFunc {
 // MetricsUpdateHandler - handler that routing from "/updates".
 // Parsing json provided batch of metric to values and updating metrics in DB.
 // If metrics with such name and kind doesn't exist, creating new metric.
-func MetricsUpdateHandler(storage metricRepository) http.HandlerFunc {
+// Hashes of metrics are checked only if request has no valid request-level signature.
+func MetricsUpdateHandler(storage metricRepository, keys *hash.Keyring) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		bytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.WriteBodyError(rw, r, err)
 			return
 		}
 		defer r.Body.Close()
@@ -200,34 +198,33 @@ func MetricsUpdateHandler(storage metricRepository) http.HandlerFunc {
 
 		err = json.Unmarshal(bytes, &jsonSlice)
 		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 			return
 		}
 
 		metricSlice := make([]metrics.Metric, 0, len(jsonSlice))
 
-		for _, jsonMetric := range jsonSlice {
-			var metric metrics.Metric
+		for i, jsonMetric := range jsonSlice {
+			metric, err := jsonMetric.ToMetric()
+			if err != nil {
+				apierror.Write(rw, r, http.StatusBadRequest, metricErrorCode(err), fmt.Sprintf("metric #%d: %s", i, err))
+				return
+			}
 
-			switch jsonMetric.MType {
-			case "gauge":
-				metric = metrics.NewMetricGauge(
-					jsonMetric.ID,
-					metrics.Gauge(*jsonMetric.Value),
-				)
-			case "counter":
-				metric = metrics.NewMetricCounter(
-					jsonMetric.ID,
-					metrics.Counter(*jsonMetric.Delta),
-				)
-			default:
-				log.Fatal("not implemented")
+			err = checkHash(r.Context(), keys, metric, jsonMetric.HashAlgorithm, jsonMetric.Hash, jsonMetric.KeyID)
+			if err != nil {
+				apierror.Write(rw, r, http.StatusBadRequest, hashErrorCode(err), fmt.Sprintf("metric #%d: %s", i, err))
+				return
 			}
 
 			metricSlice = append(metricSlice, metric)
 		}
 
-		storage.BatchUpdate(metricSlice)
+		err = storage.BatchUpdate(r.Context(), metricSlice)
+		if err != nil {
+			writeUpdateError(rw, r, err, "couldn't update metrics")
+			return
+		}
 
 		rw.Header().Set("Content-Type", "application/json")
 
@@ -242,47 +239,72 @@ func MetricsUpdateHandler(storage metricRepository) http.HandlerFunc {
 	}
 }
 
-func GRPCMetricUpdateHandler(storage metricRepository) func(ctx context.Context, request *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error) {
+// GRPCMetricUpdateHandler - handler of MetricsCollection.UpdateMetrics.
+// Hashes of metrics are checked only if request has no valid request-level signature.
+func GRPCMetricUpdateHandler(storage metricRepository, keys *hash.Keyring) func(ctx context.Context, request *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error) {
 	return func(ctx context.Context, in *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error) {
+		var alg, keyID string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(hash.AlgorithmHeader); len(values) > 0 {
+				alg = values[0]
+			}
+			if values := md.Get(hash.KeyIDHeader); len(values) > 0 {
+				keyID = values[0]
+			}
+		}
+
+		metricSlice := make([]metrics.Metric, 0, len(in.GetMetrics()))
 		for _, m := range in.GetMetrics() {
 			var metric metrics.Metric
-			switch m.MType.String() {
-			case "counter":
-				metric = metrics.NewMetricCounter(m.ID, metrics.Counter(m.GetDelta()))
-			case "gauge":
-				metric = metrics.NewMetricGauge(m.ID, metrics.Gauge(m.GetValue()))
+			switch m.GetMType() {
+			case proto.Metrics_COUNTER:
+				metric = metrics.NewMetricCounter(m.GetID(), metrics.Counter(m.GetDelta()))
+			case proto.Metrics_GAUGE:
+				metric = metrics.NewMetricGauge(m.GetID(), metrics.Gauge(m.GetValue()))
 			default:
-				return nil, errors.New("unsupported metric type")
+				err := unsupportedType(m.GetID(), m.GetMType().String())
+				return nil, apierror.Status(codes.InvalidArgument, metricErrorCode(err), err.Error())
+			}
+
+			err := validateMetric(metric)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
 			}
-			storage.Update(metric)
+
+			err = checkHash(ctx, keys, metric, alg, m.GetHash(), keyID)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "metric %q: %s", m.GetID(), err)
+			}
+
+			metricSlice = append(metricSlice, metric)
 		}
+
+		err := storage.BatchUpdate(ctx, metricSlice)
+		switch {
+		case errors.Is(err, repository.ErrBatchTooLarge):
+			return nil, apierror.Status(codes.ResourceExhausted, apierror.CodePayloadTooLarge, err.Error())
+		case errors.Is(err, repository.ErrSeriesLimit):
+			return nil, apierror.Status(codes.ResourceExhausted, apierror.CodeSeriesLimit, err.Error())
+		case errors.Is(err, repository.ErrReservedName), errors.Is(err, repository.ErrKindMismatch):
+			return nil, apierror.Status(codes.InvalidArgument, apierror.CodeInvalidMetric, err.Error())
+		case err != nil:
+			log.Println(err)
+			return nil, apierror.Status(codes.Unavailable, apierror.CodeStorageUnavailable, "couldn't update metrics")
+		}
+
 		response := &proto.BatchUpdateMetricsResponse{}
 		return response, nil
 	}
 }
 
-func updateJSONMetric(jsonMetric *JSONMetric, metric metrics.Metric) {
-	switch metric.GetKind() {
-	case "gauge":
-		value := float64(metric.GetGaugeValue())
-		jsonMetric.Value = &value
-	case "counter":
-		delta := int64(metric.GetCounterValue())
-		jsonMetric.Delta = &delta
-	default:
-		log.Fatal("Not implemented")
-	}
-}
-
 // JSONPrintHandler - handler that routing from "/value".
 // Parsing json provided data that contains metric name and kind and returning metric with such name and kind.
 // If metric with such name and kind doesn't exist returning status 404.
-func JSONPrintHandler(storage metricRepository, key string) http.HandlerFunc {
+func JSONPrintHandler(storage metricRepository, keys *hash.Keyring) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		bytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Println(err.Error())
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.WriteBodyError(rw, r, err)
 			return
 		}
 
@@ -291,41 +313,42 @@ func JSONPrintHandler(storage metricRepository, key string) http.HandlerFunc {
 
 		err = json.Unmarshal(bytes, &jsonMetric)
 		if err != nil {
-			log.Println(err.Error())
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+
+		if jsonMetric.ID == "" {
+			apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidMetric, "empty metric id")
 			return
 		}
 
-		metric, err := storage.GetMetric(jsonMetric.ID)
+		metric, err := storage.GetMetric(r.Context(), jsonMetric.ID)
+		if errors.Is(err, repository.ErrMetricNotFound) {
+			apierror.Write(rw, r, http.StatusNotFound, apierror.CodeNotFound, "metric not found")
+			return
+		}
 		if err != nil {
 			log.Println(err)
-			rw.WriteHeader(http.StatusNotFound)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeStorageUnavailable, "couldn't get metric")
 			return
 		}
 
-		updateJSONMetric(&jsonMetric, metric)
-
-		hashData, err := getHashData(metric)
+		response, err := NewJSONMetric(metric)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotImplemented)
+			log.Println(err)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
 			return
 		}
 
-		jsonMetric.Hash = hash.Get(hashData, key)
-		hashHeader := jsonMetric.Hash
-		if key != "" {
-			if !hash.Valid(hashHeader, hashData, key) {
-				log.Println(hashData)
-				rw.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			rw.Header().Set("Hash", hash.Get(hashData, key))
+		response.Hash, response.KeyID = signResponse(rw, keys, metric, jsonMetric.HashAlgorithm)
+		if response.Hash != "" {
+			response.HashAlgorithm = jsonMetric.HashAlgorithm
 		}
 
-		marshal, err := json.Marshal(jsonMetric)
+		marshal, err := json.Marshal(response)
 		if err != nil {
 			log.Println(err.Error())
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeInternal, "couldn't marshal metric")
 			return
 		}
 
@@ -345,7 +368,13 @@ func JSONPrintHandler(storage metricRepository, key string) http.HandlerFunc {
 // Printing all metrics from db.
 func PrintStorageHandler(storage metricRepository) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		mtrcs := storage.GetMetricsMap()
+		mtrcs, err := storage.GetMetricsMap(r.Context())
+		if err != nil {
+			log.Println(err)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeStorageUnavailable, "couldn't get metrics")
+			return
+		}
+
 		for _, value := range mtrcs {
 			result := value.GetKind() + " " + value.GetName() + " "
 			switch value.GetKind() {
@@ -355,7 +384,7 @@ func PrintStorageHandler(storage metricRepository) http.HandlerFunc {
 				result += fmt.Sprintf("%d", value.GetCounterValue())
 			}
 			rw.Header().Set("Content-Type", "text/html")
-			_, err := rw.Write([]byte(result))
+			_, err = rw.Write([]byte(result))
 			if err != nil {
 				log.Println("Error: Couldn't write data to response!")
 				rw.WriteHeader(http.StatusInternalServerError)
@@ -369,14 +398,19 @@ func PrintStorageHandler(storage metricRepository) http.HandlerFunc {
 // PrintValueHandler - handler that routing from "/value/kind/name".
 // Parsing metrics kind and name from query params and printing metric with such kind and name.
 // If metric with such name and kind doesn't exist, returning status 404.
-func PrintValueHandler(storage metricRepository, key string) http.HandlerFunc {
+func PrintValueHandler(storage metricRepository, keys *hash.Keyring) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		kind := chi.URLParam(r, "kind")
 		name := chi.URLParam(r, "name")
 
-		metric, err := storage.GetMetric(name)
+		metric, err := storage.GetMetric(r.Context(), name)
+		if err != nil && !errors.Is(err, repository.ErrMetricNotFound) {
+			log.Println(err)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeStorageUnavailable, "couldn't get metric")
+			return
+		}
 		if err != nil || metric.GetKind() != kind {
-			rw.WriteHeader(http.StatusNotFound)
+			apierror.Write(rw, r, http.StatusNotFound, apierror.CodeNotFound, "metric not found")
 			return
 		}
 
@@ -388,24 +422,18 @@ func PrintValueHandler(storage metricRepository, key string) http.HandlerFunc {
 		case "counter":
 			result = fmt.Sprintf("%d", metric.GetCounterValue())
 		default:
-			rw.WriteHeader(http.StatusNotImplemented)
+			err := unsupportedType(metric.GetName(), kind)
+			apierror.Write(rw, r, http.StatusBadRequest, metricErrorCode(err), err.Error())
 			return
 		}
 
-		hashData, err := getHashData(metric)
+		alg := r.Header.Get(hash.AlgorithmHeader)
+		err = checkHash(r.Context(), keys, metric, alg, r.Header.Get("Hash"), r.Header.Get(hash.KeyIDHeader))
 		if err != nil {
-			rw.WriteHeader(http.StatusNotImplemented)
+			apierror.Write(rw, r, http.StatusBadRequest, hashErrorCode(err), err.Error())
 			return
 		}
-
-		hashHeader := r.Header.Get("Hash")
-		if key != "" {
-			if !hash.Valid(hashHeader, hashData, key) {
-				rw.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			rw.Header().Set("Hash", hash.Get(hashData, key))
-		}
+		signResponse(rw, keys, metric, alg)
 
 		_, err = rw.Write([]byte(result))
 		if err != nil {
@@ -418,28 +446,14 @@ func PrintValueHandler(storage metricRepository, key string) http.HandlerFunc {
 	}
 }
 
-func getHashData(metric metrics.Metric) (string, error) {
-	var hashData string
-	switch metric.GetKind() {
-	case "gauge":
-		hashData = fmt.Sprintf(hashGaugeFormat, metric.GetName(), metric.GetKind(), metric.GetGaugeValue())
-	case "counter":
-		hashData = fmt.Sprintf(hashCounterFormat, metric.GetName(), metric.GetKind(), metric.GetCounterValue())
-	default:
-		log.Println("not implemented type")
-		return "", errors.New("not implemented type")
-	}
-	return hashData, nil
-}
-
 // PingDatabaseHandler - handler that routing from "/ping".
 // Testing connection to DB.
 func PingDatabaseHandler(db *sql.DB) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		err := db.Ping()
+		err := db.PingContext(r.Context())
 		if err != nil {
 			log.Println("Couldn't ping database")
-			rw.WriteHeader(http.StatusInternalServerError)
+			apierror.Write(rw, r, http.StatusInternalServerError, apierror.CodeStorageUnavailable, "couldn't ping database")
 			return
 		}
 
@@ -1,15 +1,28 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/testutil"
+	"github.com/VladimirMovsesyan/praktikum-devops/proto"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUpdateStorageHandler(t *testing.T) {
@@ -62,14 +75,14 @@ func TestUpdateStorageHandler(t *testing.T) {
 			},
 		},
 		{
-			name: "NotImplemented",
+			name: "UnsupportedType",
 			args: args{
 				storage: repository.NewMemStorage(),
 			},
 			target: "/update/something/test/value",
 			want: want{
 				mtrcs:      map[string]metrics.Metric{},
-				statusCode: http.StatusNotImplemented,
+				statusCode: http.StatusBadRequest,
 			},
 		},
 		{
@@ -103,7 +116,7 @@ func TestUpdateStorageHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			router := chi.NewRouter()
-			router.Post("/update/{kind}/{name}/{value}", UpdateStorageHandler(tt.args.storage, ""))
+			router.Post("/update/{kind}/{name}/{value}", UpdateStorageHandler(tt.args.storage, nil))
 
 			request := httptest.NewRequest(http.MethodPost, tt.target, nil)
 			recorder := httptest.NewRecorder()
@@ -114,7 +127,9 @@ func TestUpdateStorageHandler(t *testing.T) {
 
 			assert.Equal(t, tt.want.statusCode, result.StatusCode)
 			if tt.want.statusCode == http.StatusOK {
-				assert.Equal(t, tt.want.mtrcs, tt.args.storage.GetMetricsMap())
+				got, err := tt.args.storage.GetMetricsMap(context.Background())
+				require.NoError(t, err)
+				assert.Equal(t, tt.want.mtrcs, got)
 			}
 		})
 	}
@@ -122,10 +137,10 @@ func TestUpdateStorageHandler(t *testing.T) {
 
 func TestPrintStorageHandler(t *testing.T) {
 	storage := repository.NewMemStorage()
-	storage.Update(metrics.NewMetricCounter("testC", 123))
-	storage.Update(metrics.NewMetricGauge("testG", 123))
-	storage.Update(metrics.NewMetricCounter("testC", 321))
-	storage.Update(metrics.NewMetricGauge("testG", 321))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricCounter("testC", 123)))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricGauge("testG", 123)))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricCounter("testC", 321)))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricGauge("testG", 321)))
 
 	type want struct {
 		statusCode int
@@ -179,10 +194,10 @@ func TestPrintStorageHandler(t *testing.T) {
 
 func TestPrintValueHandler(t *testing.T) {
 	storage := repository.NewMemStorage()
-	storage.Update(metrics.NewMetricCounter("testC", 123))
-	storage.Update(metrics.NewMetricGauge("testG", 123))
-	storage.Update(metrics.NewMetricCounter("testC", 321))
-	storage.Update(metrics.NewMetricGauge("testG", 321))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricCounter("testC", 123)))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricGauge("testG", 123)))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricCounter("testC", 321)))
+	require.NoError(t, storage.Update(context.Background(), metrics.NewMetricGauge("testG", 321)))
 
 	type want struct {
 		statusCode int
@@ -236,7 +251,7 @@ func TestPrintValueHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			router := chi.NewRouter()
-			router.Get("/value/{kind}/{name}", PrintValueHandler(tt.storage, ""))
+			router.Get("/value/{kind}/{name}", PrintValueHandler(tt.storage, nil))
 
 			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
 			recorder := httptest.NewRecorder()
@@ -297,3 +312,322 @@ func TestNewJSONMetric(t *testing.T) {
 		})
 	}
 }
+
+// failingStorage - storage that rejects every write, imitating unavailable database.
+type failingStorage struct {
+	*repository.MemStorage
+}
+
+var errStorageUnavailable = errors.New("storage unavailable")
+
+func (fs failingStorage) Update(_ context.Context, _ metrics.Metric) error {
+	return errStorageUnavailable
+}
+
+func (fs failingStorage) BatchUpdate(_ context.Context, _ []metrics.Metric) error {
+	return errStorageUnavailable
+}
+
+func TestStorageFailures(t *testing.T) {
+	storage := failingStorage{MemStorage: repository.NewMemStorage()}
+
+	router := chi.NewRouter()
+	router.Post("/update/", JSONUpdateHandler(storage, nil))
+	router.Post("/update/{kind}/{name}/{value}", UpdateStorageHandler(storage, nil))
+	router.Post("/updates/", MetricsUpdateHandler(storage, nil))
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{
+			name:   "URL update",
+			target: "/update/gauge/test/1",
+		},
+		{
+			name:   "JSON update",
+			target: "/update/",
+			body:   `{"id":"test","type":"counter","delta":1}`,
+		},
+		{
+			name:   "Batch update",
+			target: "/updates/",
+			body:   `[{"id":"test","type":"gauge","value":1}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, http.StatusInternalServerError, result.StatusCode)
+		})
+	}
+
+	t.Run("gRPC update", func(t *testing.T) {
+		_, err := GRPCMetricUpdateHandler(storage, nil)(context.Background(), &proto.BatchUpdateMetricsRequest{
+			Metrics: []*proto.Metrics{{ID: "test", MType: proto.Metrics_GAUGE, Value: 1}},
+		})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Now()
+	keys := testutil.Keyring(t,
+		hash.Key{ID: "old", Secret: "oldSecret", NotAfter: now.Add(time.Hour)},
+		hash.Key{ID: "new", Secret: "newSecret", NotBefore: now.Add(-time.Minute)},
+		hash.Key{ID: "retired", Secret: "retiredSecret", NotAfter: now.Add(-time.Minute)},
+	)
+
+	router := chi.NewRouter()
+	router.Post("/update/", JSONUpdateHandler(repository.NewMemStorage(), keys))
+	router.Post("/update/{kind}/{name}/{value}", UpdateStorageHandler(repository.NewMemStorage(), keys))
+
+	hashData := "test:counter:1"
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		header     http.Header
+		statusCode int
+	}{
+		{
+			name:       "JSON signed with old key",
+			target:     "/update/",
+			body:       `{"id":"test","type":"counter","delta":1,"hash":"` + hash.Get(hashData, "oldSecret") + `","key_id":"old"}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "JSON signed with new key",
+			target:     "/update/",
+			body:       `{"id":"test","type":"counter","delta":1,"hash":"` + hash.Get(hashData, "newSecret") + `","key_id":"new"}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "JSON without key ID",
+			target:     "/update/",
+			body:       `{"id":"test","type":"counter","delta":1,"hash":"` + hash.Get(hashData, "oldSecret") + `"}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "JSON signed with retired key",
+			target:     "/update/",
+			body:       `{"id":"test","type":"counter","delta":1,"hash":"` + hash.Get(hashData, "retiredSecret") + `","key_id":"retired"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "JSON with mismatched key ID",
+			target:     "/update/",
+			body:       `{"id":"test","type":"counter","delta":1,"hash":"` + hash.Get(hashData, "oldSecret") + `","key_id":"new"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:   "URL signed with old key",
+			target: "/update/counter/test/1",
+			header: http.Header{
+				"Hash":           {hash.Get(hashData, "oldSecret")},
+				hash.KeyIDHeader: {"old"},
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "URL without hash",
+			target:     "/update/counter/test/1",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				request.Header[name] = values
+			}
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				// Responses are signed with the most recently activated key.
+				assert.Equal(t, "new", result.Header.Get(hash.KeyIDHeader))
+				assert.Equal(t, hash.Get(hashData, "newSecret"), result.Header.Get("Hash"))
+			}
+		})
+	}
+
+	grpcTests := []struct {
+		name  string
+		hash  string
+		keyID string
+		code  codes.Code
+	}{
+		{
+			name:  "gRPC signed with old key",
+			hash:  hash.Get(hashData, "oldSecret"),
+			keyID: "old",
+			code:  codes.OK,
+		},
+		{
+			name:  "gRPC signed with retired key",
+			hash:  hash.Get(hashData, "retiredSecret"),
+			keyID: "retired",
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "gRPC with mismatched key ID",
+			hash:  hash.Get(hashData, "oldSecret"),
+			keyID: "new",
+			code:  codes.InvalidArgument,
+		},
+	}
+
+	handler := GRPCMetricUpdateHandler(repository.NewMemStorage(), keys)
+	for _, tt := range grpcTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(hash.KeyIDHeader, tt.keyID))
+			_, err := handler(ctx, &proto.BatchUpdateMetricsRequest{
+				Metrics: []*proto.Metrics{{ID: "test", MType: proto.Metrics_COUNTER, Delta: 1, Hash: tt.hash}},
+			})
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestMetricsUpdateHandler_Hashes(t *testing.T) {
+	keys := testutil.Keyring(t, hash.Key{Secret: "superSecretKey"})
+
+	verifier := signature.NewVerifier(keys, signature.DefaultWindow, false)
+	router := chi.NewRouter()
+	router.With(verifier.Middleware).Post("/updates/", MetricsUpdateHandler(repository.NewMemStorage(), keys))
+
+	signedHash := hash.Get("test:counter:1", "superSecretKey")
+
+	tests := []struct {
+		name       string
+		body       string
+		sign       bool
+		statusCode int
+	}{
+		{
+			name:       "Valid metric hashes",
+			body:       `[{"id":"test","type":"counter","delta":1,"hash":"` + signedHash + `"}]`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Missing metric hash",
+			body:       `[{"id":"test","type":"counter","delta":1,"hash":"` + signedHash + `"},{"id":"other","type":"counter","delta":1}]`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Signed request without metric hashes",
+			body:       `[{"id":"test","type":"counter","delta":1},{"id":"other","type":"gauge","value":0.123456789}]`,
+			sign:       true,
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
+			if tt.sign {
+				require.NoError(t, signature.Sign(request, []byte(tt.body), keys, hash.SHA256))
+			}
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	keys := testutil.Keyring(t, hash.Key{Secret: "superSecretKey"})
+
+	router := chi.NewRouter()
+	router.Post("/update/", JSONUpdateHandler(repository.NewMemStorage(), keys))
+
+	metric := metrics.NewMetricGauge("Alloc", 1e-9)
+	canonical, err := hash.SHA512.MetricData(metric)
+	require.NoError(t, err)
+	legacy, err := hash.Legacy.MetricData(metric)
+	require.NoError(t, err)
+	// Legacy data of the other gauge is the same, so legacy hash can't tell them apart.
+	other, err := hash.SHA512.MetricData(metrics.NewMetricGauge("Alloc", 2e-9))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		hash       string
+		alg        string
+		statusCode int
+		code       string
+	}{
+		{
+			name:       "SHA-512 over canonical encoding",
+			hash:       hash.SHA512.Sum(canonical, "superSecretKey"),
+			alg:        string(hash.SHA512),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Legacy agent",
+			hash:       hash.Legacy.Sum(legacy, "superSecretKey"),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Hash of other value",
+			hash:       hash.SHA512.Sum(other, "superSecretKey"),
+			alg:        string(hash.SHA512),
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeHashMismatch,
+		},
+		{
+			name:       "Legacy hash with declared algorithm",
+			hash:       hash.SHA256.Sum(legacy, "superSecretKey"),
+			alg:        string(hash.SHA256),
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeHashMismatch,
+		},
+		{
+			name:       "Unsupported algorithm",
+			hash:       hash.SHA256.Sum(canonical, "superSecretKey"),
+			alg:        "hmac-md5",
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"id":"Alloc","type":"gauge","value":1e-9,"hash":"` + tt.hash + `","hash_algorithm":"` + tt.alg + `"}`
+			request := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.code != "" {
+				var response apierror.Response
+				require.NoError(t, json.NewDecoder(result.Body).Decode(&response))
+				assert.Equal(t, tt.code, response.Code)
+				return
+			}
+			assert.Equal(t, tt.alg, result.Header.Get(hash.AlgorithmHeader))
+		})
+	}
+}
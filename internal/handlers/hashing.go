@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
+	"log"
+	"net/http"
+)
+
+var errHashMismatch = errors.New("hash mismatch")
+
+// checkHash - checks hash of metric computed with declared algorithm by key with keyID.
+// Check is skipped if server has no keys or request has valid request-level signature.
+func checkHash(ctx context.Context, keys *hash.Keyring, metric metrics.Metric, alg, sum, keyID string) error {
+	if !keys.Enabled() || signature.Verified(ctx) {
+		return nil
+	}
+
+	algorithm, err := hash.ParseAlgorithm(alg)
+	if err != nil {
+		return err
+	}
+
+	data, err := algorithm.MetricData(metric)
+	if err != nil {
+		return err
+	}
+
+	if !keys.Valid(algorithm, sum, data, keyID) {
+		return errHashMismatch
+	}
+
+	return nil
+}
+
+// hashErrorCode - returns code of error response for error of checkHash.
+func hashErrorCode(err error) string {
+	if errors.Is(err, errHashMismatch) {
+		return apierror.CodeHashMismatch
+	}
+	return apierror.CodeInvalidRequest
+}
+
+// signResponse - sets Hash, key ID and algorithm headers of response with the algorithm declared by client,
+// returns hash and key ID for response body.
+func signResponse(rw http.ResponseWriter, keys *hash.Keyring, metric metrics.Metric, alg string) (string, string) {
+	if !keys.Enabled() {
+		return "", ""
+	}
+
+	algorithm, err := hash.ParseAlgorithm(alg)
+	if err != nil {
+		log.Println(err)
+		return "", ""
+	}
+
+	data, err := algorithm.MetricData(metric)
+	if err != nil {
+		log.Println(err)
+		return "", ""
+	}
+
+	sum, keyID, err := keys.Sign(algorithm, data)
+	if err != nil {
+		log.Println(err)
+		return "", ""
+	}
+
+	rw.Header().Set("Hash", sum)
+	if keyID != "" {
+		rw.Header().Set(hash.KeyIDHeader, keyID)
+	}
+	if algorithm != hash.Legacy {
+		rw.Header().Set(hash.AlgorithmHeader, string(algorithm))
+	}
+
+	return sum, keyID
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLength - max length of metric name, equals to metric_name column size.
+const maxNameLength = wire.MaxNameLength
+
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// ErrUnsupportedType - metric type is neither gauge nor counter, answered with 400 on every route.
+var ErrUnsupportedType = fmt.Errorf("%w: unsupported type", ErrInvalidMetric)
+
+// ToMetric - validates json representation of metric and converts it to metric.
+func (jm JSONMetric) ToMetric() (metrics.Metric, error) {
+	var metric metrics.Metric
+
+	switch jm.MType {
+	case "gauge":
+		if jm.Value == nil {
+			return metrics.Metric{}, fmt.Errorf("%w %q: gauge requires value", ErrInvalidMetric, jm.ID)
+		}
+		metric = metrics.NewMetricGauge(jm.ID, metrics.Gauge(*jm.Value))
+	case "counter":
+		if jm.Delta == nil {
+			return metrics.Metric{}, fmt.Errorf("%w %q: counter requires delta", ErrInvalidMetric, jm.ID)
+		}
+		metric = metrics.NewMetricCounter(jm.ID, metrics.Counter(*jm.Delta))
+	default:
+		return metrics.Metric{}, unsupportedType(jm.ID, jm.MType)
+	}
+
+	err := validateMetric(metric)
+	if err != nil {
+		return metrics.Metric{}, err
+	}
+
+	return metric, nil
+}
+
+// unsupportedType - returns error for metric of unknown type.
+func unsupportedType(name, kind string) error {
+	return fmt.Errorf("%w %q of metric %q", ErrUnsupportedType, kind, name)
+}
+
+// metricErrorCode - returns code of error response for invalid metric, the same for url, json and protobuf.
+func metricErrorCode(err error) string {
+	if errors.Is(err, ErrUnsupportedType) {
+		return apierror.CodeUnsupportedType
+	}
+	return apierror.CodeInvalidMetric
+}
+
+// validateMetric - checks metric regardless of its source: url, json or protobuf.
+func validateMetric(metric metrics.Metric) error {
+	name := metric.GetName()
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidMetric)
+	}
+
+	if len(name) > maxNameLength {
+		return fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidMetric, maxNameLength)
+	}
+
+	if !utf8.ValidString(name) || strings.ContainsRune(name, 0) {
+		return fmt.Errorf("%w %q: name must be valid utf-8 without NUL", ErrInvalidMetric, name)
+	}
+
+	if metric.GetKind() == "gauge" {
+		value := float64(metric.GetGaugeValue())
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("%w %q: value must be finite", ErrInvalidMetric, name)
+		}
+	}
+
+	return nil
+}
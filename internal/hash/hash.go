@@ -3,15 +3,105 @@ package hash
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"hash"
+	"math"
 )
 
+// AlgorithmHeader - header that declares algorithm of Hash header.
+const AlgorithmHeader = "Hash-Algorithm"
+
+// Algorithm - HMAC algorithm declared by client. Legacy is used by clients that declare nothing:
+// HMAC-SHA256 over text with %f-formatted gauges. Declared algorithms sign canonical binary encoding of metric.
+type Algorithm string
+
+const (
+	Legacy Algorithm = ""
+	SHA256 Algorithm = "hmac-sha256"
+	SHA512 Algorithm = "hmac-sha512"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported hash algorithm")
+
+// ParseAlgorithm - parses declared algorithm, empty string means Legacy.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch alg := Algorithm(s); alg {
+	case Legacy, SHA256, SHA512:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, s)
+	}
+}
+
+func (a Algorithm) newHash() func() hash.Hash {
+	if a == SHA512 {
+		return sha512.New
+	}
+	return sha256.New
+}
+
+// Sum - returns hex HMAC of data.
+func (a Algorithm) Sum(data []byte, key string) string {
+	mac := hmac.New(a.newHash(), []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Valid - checks hex HMAC of data in constant time.
+func (a Algorithm) Valid(sum string, data []byte, key string) bool {
+	decoded, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(a.newHash(), []byte(key))
+	mac.Write(data)
+	return hmac.Equal(decoded, mac.Sum(nil))
+}
+
+// MetricData - returns data of metric that is signed with algorithm.
+func (a Algorithm) MetricData(metric metrics.Metric) ([]byte, error) {
+	var bits uint64
+	switch metric.GetKind() {
+	case "gauge":
+		if a == Legacy {
+			return []byte(fmt.Sprintf("%s:%s:%f", metric.GetName(), metric.GetKind(), metric.GetGaugeValue())), nil
+		}
+		bits = math.Float64bits(float64(metric.GetGaugeValue()))
+	case "counter":
+		if a == Legacy {
+			return []byte(fmt.Sprintf("%s:%s:%d", metric.GetName(), metric.GetKind(), metric.GetCounterValue())), nil
+		}
+		bits = uint64(metric.GetCounterValue())
+	default:
+		return nil, errors.New("not implemented type")
+	}
+
+	return encode(metric.GetKind(), metric.GetName(), bits), nil
+}
+
+// encode - canonical encoding: length-prefixed kind and name, then big-endian bits of value,
+// so neither formatting nor separators inside name make two metrics sign the same data.
+func encode(kind, name string, bits uint64) []byte {
+	data := make([]byte, 0, 2*binary.MaxVarintLen64+len(kind)+len(name)+8)
+	data = binary.AppendUvarint(data, uint64(len(kind)))
+	data = append(data, kind...)
+	data = binary.AppendUvarint(data, uint64(len(name)))
+	data = append(data, name...)
+	return binary.BigEndian.AppendUint64(data, bits)
+}
+
+// Get - returns hex HMAC-SHA256 of data.
 func Get(data, key string) string {
-	hash := hmac.New(sha256.New, []byte(key))
-	hash.Write([]byte(data))
-	return hex.EncodeToString(hash.Sum(nil))
+	return SHA256.Sum([]byte(data), key)
 }
 
+// Valid - checks hex HMAC-SHA256 of data in constant time.
 func Valid(hash, data, key string) bool {
-	return hash == Get(data, key)
+	return SHA256.Valid(hash, []byte(data), key)
 }
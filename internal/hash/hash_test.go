@@ -1,7 +1,11 @@
 package hash
 
 import (
+	"bytes"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
 	"github.com/stretchr/testify/require"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -70,3 +74,155 @@ func TestValid(t *testing.T) {
 		})
 	}
 }
+
+func TestValid_Mismatch(t *testing.T) {
+	key := "superSecretKey"
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{
+			name: "other data",
+			hash: Get("31231212313", key),
+		},
+		{
+			name: "not hex",
+			hash: "zz14d32b12b5a058fab4d9e9d3be2d9250e42ae9c9fc7e2f6b0a9995f95c3dae",
+		},
+		{
+			name: "truncated",
+			hash: "7414d32b12b5a058fab4d9e9d3be2d92",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.False(t, Valid(tt.hash, "31231212312", key))
+		})
+	}
+}
+
+func TestAlgorithm_Sum(t *testing.T) {
+	key := "superSecretKey"
+	data := []byte("31231212312")
+
+	tests := []struct {
+		name    string
+		alg     Algorithm
+		wantLen int
+	}{
+		{
+			name:    "legacy",
+			alg:     Legacy,
+			wantLen: 64,
+		},
+		{
+			name:    "sha256",
+			alg:     SHA256,
+			wantLen: 64,
+		},
+		{
+			name:    "sha512",
+			alg:     SHA512,
+			wantLen: 128,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := tt.alg.Sum(data, key)
+			require.Len(t, sum, tt.wantLen)
+			require.True(t, tt.alg.Valid(sum, data, key))
+			require.True(t, tt.alg.Valid(strings.ToUpper(sum), data, key))
+			require.False(t, tt.alg.Valid(sum, data, "otherKey"))
+		})
+	}
+
+	require.False(t, SHA512.Valid(SHA256.Sum(data, key), data, key))
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Algorithm
+		wantErr bool
+	}{
+		{value: "", want: Legacy},
+		{value: "hmac-sha256", want: SHA256},
+		{value: "hmac-sha512", want: SHA512},
+		{value: "hmac-md5", wantErr: true},
+		{value: "HMAC-SHA256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			alg, err := ParseAlgorithm(tt.value)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, alg)
+		})
+	}
+}
+
+func TestAlgorithm_MetricData(t *testing.T) {
+	tests := []struct {
+		name      string
+		alg       Algorithm
+		first     metrics.Metric
+		second    metrics.Metric
+		wantEqual bool
+	}{
+		{
+			name:      "legacy loses small gauges",
+			alg:       Legacy,
+			first:     metrics.NewMetricGauge("Alloc", 1e-9),
+			second:    metrics.NewMetricGauge("Alloc", 2e-9),
+			wantEqual: true,
+		},
+		{
+			name:   "small gauges",
+			alg:    SHA256,
+			first:  metrics.NewMetricGauge("Alloc", 1e-9),
+			second: metrics.NewMetricGauge("Alloc", 2e-9),
+		},
+		{
+			name:   "large gauges",
+			alg:    SHA256,
+			first:  metrics.NewMetricGauge("Alloc", 1e300),
+			second: metrics.NewMetricGauge("Alloc", metrics.Gauge(math.Nextafter(1e300, math.Inf(1)))),
+		},
+		{
+			name:   "separator inside name",
+			alg:    SHA512,
+			first:  metrics.NewMetricCounter("a:counter", 1),
+			second: metrics.NewMetricCounter("a", 1),
+		},
+		{
+			name:   "gauge and counter with same bits",
+			alg:    SHA256,
+			first:  metrics.NewMetricGauge("x", metrics.Gauge(math.Float64frombits(1))),
+			second: metrics.NewMetricCounter("x", 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := tt.alg.MetricData(tt.first)
+			require.NoError(t, err)
+			second, err := tt.alg.MetricData(tt.second)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.wantEqual, bytes.Equal(first, second))
+		})
+	}
+
+	legacy, err := Legacy.MetricData(metrics.NewMetricCounter("PollCount", 5))
+	require.NoError(t, err)
+	require.Equal(t, "PollCount:counter:5", string(legacy))
+}
@@ -0,0 +1,159 @@
+package hash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyIDHeader - header with ID of the key that signed Hash header.
+const KeyIDHeader = "Hash-Key-ID"
+
+// Key - HMAC signing key. Key is active from NotBefore till NotAfter, zero time means no bound.
+type Key struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+}
+
+// Active - reports whether key may be used at moment t.
+func (k Key) Active(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && !t.Before(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// Keyring - set of signing keys with IDs, so keys are rotated by overlapping activation windows.
+// Nil or empty keyring disables signing and verification.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []Key
+	now  func() time.Time
+}
+
+// NewKeyring - creates keyring from keys, IDs must be unique.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	kr := &Keyring{now: time.Now}
+
+	err := kr.Set(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	return kr, nil
+}
+
+// LoadKeys - reads keys from JSON file with array of keys.
+func LoadKeys(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+
+	return keys, nil
+}
+
+// Set - replaces keys of keyring, keeps previous keys on failure.
+func (kr *Keyring) Set(keys ...Key) error {
+	ids := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if key.Secret == "" {
+			return fmt.Errorf("key %q: empty secret", key.ID)
+		}
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			return fmt.Errorf("key %q: not_after must be later than not_before", key.ID)
+		}
+		if _, ok := ids[key.ID]; ok {
+			return fmt.Errorf("key %q: duplicate id", key.ID)
+		}
+		ids[key.ID] = struct{}{}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.keys = append([]Key(nil), keys...)
+	return nil
+}
+
+// Enabled - reports whether keyring has any keys, even expired ones.
+func (kr *Keyring) Enabled() bool {
+	if kr == nil {
+		return false
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return len(kr.keys) > 0
+}
+
+// Sign - signs data with the most recently activated active key, returns hash and ID of the key.
+func (kr *Keyring) Sign(alg Algorithm, data []byte) (string, string, error) {
+	key, ok := kr.signingKey()
+	if !ok {
+		return "", "", errors.New("no active signing key")
+	}
+
+	return alg.Sum(data, key.Secret), key.ID, nil
+}
+
+// Valid - checks hash with active key of given ID. If ID is empty, every active key is tried.
+func (kr *Keyring) Valid(alg Algorithm, hash string, data []byte, keyID string) bool {
+	if kr == nil {
+		return false
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := kr.now()
+	for _, key := range kr.keys {
+		if keyID != "" && key.ID != keyID {
+			continue
+		}
+		if key.Active(now) && alg.Valid(hash, data, key.Secret) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (kr *Keyring) signingKey() (Key, bool) {
+	if kr == nil {
+		return Key{}, false
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := kr.now()
+	var result Key
+	found := false
+	for _, key := range kr.keys {
+		if !key.Active(now) {
+			continue
+		}
+		if !found || !key.NotBefore.Before(result.NotBefore) {
+			result = key
+			found = true
+		}
+	}
+
+	return result, found
+}
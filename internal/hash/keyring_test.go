@@ -0,0 +1,183 @@
+package hash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyring_Valid(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	data := "Alloc:gauge:1.000000"
+
+	keyring, err := NewKeyring(
+		Key{ID: "old", Secret: "oldSecret", NotAfter: now.Add(time.Hour)},
+		Key{ID: "new", Secret: "newSecret", NotBefore: now.Add(-time.Hour)},
+		Key{ID: "next", Secret: "nextSecret", NotBefore: now.Add(time.Hour)},
+		Key{ID: "expired", Secret: "expiredSecret", NotAfter: now},
+	)
+	require.NoError(t, err)
+	keyring.now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		secret string
+		keyID  string
+		want   bool
+	}{
+		{
+			name:   "Old key with ID",
+			secret: "oldSecret",
+			keyID:  "old",
+			want:   true,
+		},
+		{
+			name:   "New key with ID",
+			secret: "newSecret",
+			keyID:  "new",
+			want:   true,
+		},
+		{
+			name:   "Active key without ID",
+			secret: "newSecret",
+			want:   true,
+		},
+		{
+			name:   "Key with wrong ID",
+			secret: "oldSecret",
+			keyID:  "new",
+		},
+		{
+			name:   "Unknown ID",
+			secret: "oldSecret",
+			keyID:  "unknown",
+		},
+		{
+			name:   "Not yet active key",
+			secret: "nextSecret",
+			keyID:  "next",
+		},
+		{
+			name:   "Expired key",
+			secret: "expiredSecret",
+			keyID:  "expired",
+		},
+		{
+			name:   "Expired key without ID",
+			secret: "expiredSecret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, keyring.Valid(SHA256, Get(data, tt.secret), []byte(data), tt.keyID))
+		})
+	}
+}
+
+func TestKeyring_Sign(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	data := "PollCount:counter:1"
+
+	keyring, err := NewKeyring(
+		Key{ID: "old", Secret: "oldSecret"},
+		Key{ID: "new", Secret: "newSecret", NotBefore: now.Add(-time.Minute)},
+		Key{ID: "next", Secret: "nextSecret", NotBefore: now.Add(time.Minute)},
+	)
+	require.NoError(t, err)
+	keyring.now = func() time.Time { return now }
+
+	sum, keyID, err := keyring.Sign(SHA256, []byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, "new", keyID)
+	assert.Equal(t, Get(data, "newSecret"), sum)
+
+	// Pre-distributed key takes over once it is activated.
+	keyring.now = func() time.Time { return now.Add(time.Hour) }
+	_, keyID, err = keyring.Sign(SHA256, []byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, "next", keyID)
+
+	expired, err := NewKeyring(Key{ID: "expired", Secret: "secret", NotAfter: now})
+	require.NoError(t, err)
+	expired.now = func() time.Time { return now }
+	_, _, err = expired.Sign(SHA256, []byte(data))
+	require.Error(t, err)
+}
+
+func TestKeyring_Disabled(t *testing.T) {
+	var nilKeyring *Keyring
+	assert.False(t, nilKeyring.Enabled())
+	assert.False(t, nilKeyring.Valid(SHA256, "", []byte("data"), ""))
+
+	empty, err := NewKeyring()
+	require.NoError(t, err)
+	assert.False(t, empty.Enabled())
+}
+
+func TestKeyring_Set(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		keys    []Key
+		wantErr bool
+	}{
+		{
+			name: "Legacy key without ID",
+			keys: []Key{{Secret: "secret"}},
+		},
+		{
+			name:    "Empty secret",
+			keys:    []Key{{ID: "a"}},
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate ID",
+			keys:    []Key{{ID: "a", Secret: "first"}, {ID: "a", Secret: "second"}},
+			wantErr: true,
+		},
+		{
+			name:    "Empty window",
+			keys:    []Key{{ID: "a", Secret: "secret", NotBefore: now, NotAfter: now}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := NewKeyring(Key{ID: "previous", Secret: "secret"})
+			require.NoError(t, err)
+
+			err = keyring.Set(tt.keys...)
+			if tt.wantErr {
+				require.Error(t, err)
+				// Previous keys are kept.
+				assert.True(t, keyring.Valid(SHA256, Get("data", "secret"), []byte("data"), "previous"))
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "2023-04", "secret": "old", "not_after": "2023-05-02T00:00:00Z"},
+		{"id": "2023-05", "secret": "new", "not_before": "2023-05-01T00:00:00Z"}
+	]`), 0600))
+
+	keys, err := LoadKeys(path)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "2023-05", keys[1].ID)
+	assert.Equal(t, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), keys[1].NotBefore)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0600))
+	_, err = LoadKeys(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package ipfilter
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+)
+
+// PeerIP - returns ip of gRPC peer of ctx.
+func PeerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	return remoteIP(p.Addr.String())
+}
+
+// UnaryServerInterceptor - lets through only calls from peers whose address passes filter.
+func (f *Filter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !f.Enabled() {
+			return handler(ctx, req)
+		}
+
+		ip := PeerIP(ctx)
+		if !f.Allowed(ip) {
+			log.Printf("Client IP: '%s' is not allowed\n", ip)
+			return nil, status.Error(codes.PermissionDenied, "client ip is not in trusted subnet")
+		}
+
+		return handler(ctx, req)
+	}
+}
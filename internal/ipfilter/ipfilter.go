@@ -0,0 +1,172 @@
+package ipfilter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseNets - parses comma separated list of CIDRs and single IPv4 or IPv6 addresses.
+func ParseNets(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter - allow and deny lists of subnets. Deny list wins, empty allow list allows every address
+// that isn't denied. Nil filter allows everything.
+type Filter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// New - creates filter from comma separated allow and deny lists.
+func New(allow, deny string) (*Filter, error) {
+	allowNets, err := ParseNets(allow)
+	if err != nil {
+		return nil, fmt.Errorf("allowed subnets: %w", err)
+	}
+
+	denyNets, err := ParseNets(deny)
+	if err != nil {
+		return nil, fmt.Errorf("denied subnets: %w", err)
+	}
+
+	return &Filter{allow: allowNets, deny: denyNets}, nil
+}
+
+// Enabled - reports whether filter has any subnets.
+func (f *Filter) Enabled() bool {
+	return f != nil && len(f.allow)+len(f.deny) > 0
+}
+
+// Allowed - reports whether ip passes filter. Unknown ip passes only disabled filter.
+func (f *Filter) Allowed(ip net.IP) bool {
+	if !f.Enabled() {
+		return true
+	}
+	if ip == nil || contains(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, ip)
+}
+
+type clientIPKey struct{}
+
+// FromContext - returns client ip resolved by Resolver for request of ctx.
+func FromContext(ctx context.Context) (net.IP, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(net.IP)
+	return ip, ok
+}
+
+// ClientIP - returns client ip resolved by Resolver, or ip of remote address if request didn't pass it.
+func ClientIP(r *http.Request) net.IP {
+	if ip, ok := FromContext(r.Context()); ok {
+		return ip
+	}
+	return remoteIP(r.RemoteAddr)
+}
+
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// Resolver - derives client ip from connection. Forwarding headers are trusted only
+// when connection comes from one of trusted proxies.
+type Resolver struct {
+	proxies []*net.IPNet
+}
+
+// NewResolver - creates resolver trusting comma separated list of proxy subnets.
+func NewResolver(proxies string) (*Resolver, error) {
+	nets, err := ParseNets(proxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	return &Resolver{proxies: nets}, nil
+}
+
+// Resolve - returns client ip of request. X-Forwarded-For is read from the right, skipping trusted
+// proxies, so entries prepended by client are never used. X-Real-IP is used if there is no X-Forwarded-For.
+func (res *Resolver) Resolve(r *http.Request) net.IP {
+	ip := remoteIP(r.RemoteAddr)
+	if res == nil || ip == nil || !contains(res.proxies, ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				return ip
+			}
+			ip = hop
+			if !contains(res.proxies, hop) {
+				return ip
+			}
+		}
+		return ip
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP
+	}
+
+	return ip
+}
+
+// Middleware - stores resolved client ip in request context and remote address, so it is logged.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			ip := res.Resolve(r)
+			if ip != nil {
+				r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(rw, r)
+		},
+	)
+}
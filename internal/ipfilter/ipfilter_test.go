@@ -0,0 +1,159 @@
+package ipfilter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseNets(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Empty list",
+			list: "",
+		},
+		{
+			name: "CIDRs and addresses",
+			list: "10.0.0.0/8, 192.168.1.1,2001:db8::/32,::1",
+			want: []string{"10.0.0.0/8", "192.168.1.1/32", "2001:db8::/32", "::1/128"},
+		},
+		{
+			name:    "Address with port",
+			list:    "192.168.1.1:8080",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid mask",
+			list:    "10.0.0.0/33",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nets, err := ParseNets(tt.list)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var got []string
+			for _, ipNet := range nets {
+				got = append(got, ipNet.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFilter_Allowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow string
+		deny  string
+		ip    string
+		want  bool
+	}{
+		{name: "Disabled filter", ip: "8.8.8.8", want: true},
+		{name: "Allowed IPv4", allow: "10.0.0.0/8,172.16.0.0/12", ip: "172.16.5.4", want: true},
+		{name: "Not allowed IPv4", allow: "10.0.0.0/8,172.16.0.0/12", ip: "192.168.0.1", want: false},
+		{name: "Allowed IPv6", allow: "2001:db8::/32", ip: "2001:db8::1", want: true},
+		{name: "Not allowed IPv6", allow: "2001:db8::/32", ip: "2001:db9::1", want: false},
+		{name: "IPv4-mapped IPv6", allow: "10.0.0.0/8", ip: "::ffff:10.1.2.3", want: true},
+		{name: "Deny wins", allow: "10.0.0.0/8", deny: "10.0.0.13", ip: "10.0.0.13", want: false},
+		{name: "Deny only", deny: "10.0.0.0/8", ip: "192.168.0.1", want: true},
+		{name: "Unknown address", allow: "10.0.0.0/8", ip: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(tt.allow, tt.deny)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, f.Allowed(net.ParseIP(tt.ip)))
+		})
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	res, err := NewResolver("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{
+			name:   "Direct connection",
+			remote: "203.0.113.7:51234",
+			want:   "203.0.113.7",
+		},
+		{
+			name:   "Headers from untrusted peer are ignored",
+			remote: "203.0.113.7:51234",
+			realIP: "10.1.1.1",
+			want:   "203.0.113.7",
+		},
+		{
+			name:      "Forwarded by trusted proxy",
+			remote:    "10.0.0.2:443",
+			forwarded: "198.51.100.1",
+			want:      "198.51.100.1",
+		},
+		{
+			name:      "Spoofed entry before client is skipped",
+			remote:    "10.0.0.2:443",
+			forwarded: "10.9.9.9, 198.51.100.1, 10.0.0.3",
+			want:      "198.51.100.1",
+		},
+		{
+			name:   "Real IP from trusted proxy",
+			remote: "10.0.0.2:443",
+			realIP: "2001:db8::5",
+			want:   "2001:db8::5",
+		},
+		{
+			name:      "Malformed forwarded entry",
+			remote:    "10.0.0.2:443",
+			forwarded: "garbage, 10.0.0.3",
+			want:      "10.0.0.3",
+		},
+		{
+			name:   "IPv6 peer",
+			remote: "[2001:db8::1]:51234",
+			want:   "2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				request.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			assert.Equal(t, tt.want, res.Resolve(request).String())
+
+			var got net.IP
+			res.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), request)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"compress/gzip"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
+	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"strings"
 )
@@ -18,6 +21,16 @@ func (gw gzipWriter) Write(p []byte) (int, error) {
 	return gw.writer.Write(p)
 }
 
+// RequestID - assigns request id with chi's RequestID middleware and passes it to error responses.
+func RequestID(next http.Handler) http.Handler {
+	return chiMiddleware.RequestID(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			ctx := apierror.WithRequestID(r.Context(), chiMiddleware.GetReqID(r.Context()))
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		},
+	))
+}
+
 func Compress(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(rw http.ResponseWriter, r *http.Request) {
@@ -50,8 +63,7 @@ func Decompress(next http.Handler) http.Handler {
 
 			reader, err := gzip.NewReader(r.Body)
 			if err != nil {
-				log.Println(err)
-				rw.WriteHeader(http.StatusInternalServerError)
+				apierror.Write(rw, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid gzip body")
 				return
 			}
 			defer reader.Close()
@@ -62,31 +74,42 @@ func Decompress(next http.Handler) http.Handler {
 	)
 }
 
-func SubnetCheck(subnet string) func(http.Handler) http.Handler {
+// SubnetCheck - lets through only requests whose client ip passes filter.
+// Client ip is taken from connection, or from ipfilter.Resolver if it runs before.
+func SubnetCheck(filter *ipfilter.Filter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(rw http.ResponseWriter, r *http.Request) {
-				if subnet == "" {
-					next.ServeHTTP(rw, r)
+				ip := ipfilter.ClientIP(r)
+				if !filter.Allowed(ip) {
+					log.Printf("Client IP: '%s' is not allowed\n", ip)
+					apierror.Write(rw, r, http.StatusForbidden, apierror.CodeForbidden, "client ip is not in trusted subnet")
 					return
 				}
 
-				ipHeader := r.Header.Get("X-Real-IP")
-				ip := net.ParseIP(ipHeader)
+				next.ServeHTTP(rw, r)
+			},
+		)
+	}
+}
 
-				_, ipv4Net, err := net.ParseCIDR(subnet)
-				if err != nil {
-					log.Println(err)
-					rw.WriteHeader(http.StatusInternalServerError)
+// MaxBytes - limits size of request body. Requests declaring larger Content-Length are rejected at once,
+// other bodies fail to read past limit. Zero limit means no limit.
+func MaxBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(rw http.ResponseWriter, r *http.Request) {
+				if limit <= 0 {
+					next.ServeHTTP(rw, r)
 					return
 				}
 
-				if !ipv4Net.Contains(ip) {
-					log.Printf("Client IP: '%s' is not in subnet: '%s'\n", ipHeader, subnet)
-					rw.WriteHeader(http.StatusForbidden)
+				if r.ContentLength > limit {
+					apierror.Write(rw, r, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, fmt.Sprintf("request body is larger than %d bytes", limit))
 					return
 				}
 
+				r.Body = http.MaxBytesReader(rw, r.Body, limit)
 				next.ServeHTTP(rw, r)
 			},
 		)
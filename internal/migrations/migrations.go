@@ -0,0 +1,297 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const (
+	// lockID - key of postgres advisory lock that serializes migrations between server instances.
+	lockID int64 = 4387216501
+
+	versionsTableCreation = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR (255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+);`
+
+	upSuffix   = ".up.sql"
+	downSuffix = ".down.sql"
+)
+
+// Migration - contains version, name and queries of one schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status - contains migration and information about its applying.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator - applies and reverts embedded migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := load(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// load - reads pairs of "<version>_<name>.up.sql" and "<version>_<name>.down.sql" files sorted by version.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		filename := entry.Name()
+
+		var suffix string
+		switch {
+		case strings.HasSuffix(filename, upSuffix):
+			suffix = upSuffix
+		case strings.HasSuffix(filename, downSuffix):
+			suffix = downSuffix
+		default:
+			continue
+		}
+
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(filename, suffix), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %q: expected <version>_<name>%s", filename, suffix)
+		}
+
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %q: invalid version %q", filename, versionStr)
+		}
+
+		query, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration %d: name mismatch %q and %q", version, migration.Name, name)
+		}
+
+		switch suffix {
+		case upSuffix:
+			migration.Up = string(query)
+		case downSuffix:
+			migration.Down = string(query)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: both up and down files are required", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up - applies all pending migrations and returns their count.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			log.Printf("applying migration %d_%s\n", migration.Version, migration.Name)
+			err = execInTx(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now(),
+			)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down - reverts last applied migrations, at most steps of them, and returns their count.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			log.Printf("reverting migration %d_%s\n", migration.Version, migration.Name)
+			err = execInTx(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`,
+				migration.Version,
+			)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status - returns all known migrations with information about their applying.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = make([]Status, 0, len(m.migrations))
+		for _, migration := range m.migrations {
+			appliedAt, ok := versions[migration.Version]
+			statuses = append(statuses, Status{
+				Migration: migration,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withLock - runs f on a single connection holding the migrations advisory lock.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, versionsTableCreation)
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+// execInTx - executes migration query and bookkeeping query in one transaction.
+func execInTx(ctx context.Context, conn *sql.Conn, query, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
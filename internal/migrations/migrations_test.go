@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		want     []Migration
+		hasError bool
+	}{
+		{
+			name: "Sorted by version",
+			fsys: fstest.MapFS{
+				"0010_second.up.sql":   {Data: []byte("up 10")},
+				"0010_second.down.sql": {Data: []byte("down 10")},
+				"0002_first.up.sql":    {Data: []byte("up 2")},
+				"0002_first.down.sql":  {Data: []byte("down 2")},
+				"README.md":            {Data: []byte("ignored")},
+			},
+			want: []Migration{
+				{Version: 2, Name: "first", Up: "up 2", Down: "down 2"},
+				{Version: 10, Name: "second", Up: "up 10", Down: "down 10"},
+			},
+		},
+		{
+			name: "Missing down",
+			fsys: fstest.MapFS{
+				"0001_first.up.sql": {Data: []byte("up 1")},
+			},
+			hasError: true,
+		},
+		{
+			name: "Invalid version",
+			fsys: fstest.MapFS{
+				"first_table.up.sql":   {Data: []byte("up")},
+				"first_table.down.sql": {Data: []byte("down")},
+			},
+			hasError: true,
+		},
+		{
+			name: "Name mismatch",
+			fsys: fstest.MapFS{
+				"0001_first.up.sql":    {Data: []byte("up 1")},
+				"0001_other.down.sql":  {Data: []byte("down 1")},
+				"0001_first.down.sql":  {Data: []byte("down 1")},
+				"0001_other.up.sql":    {Data: []byte("up 1")},
+				"0002_second.up.sql":   {Data: []byte("up 2")},
+				"0002_second.down.sql": {Data: []byte("down 2")},
+			},
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := load(tt.fsys)
+			if tt.hasError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	migrator, err := New(nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrator.migrations)
+
+	for i, migration := range migrator.migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "embedded migrations must have sequential versions")
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS metric (
+    metric_name VARCHAR (50) PRIMARY KEY,
+    metric_type VARCHAR (10) NOT NULL,
+    metric_delta BIGINT,
+    metric_value DOUBLE PRECISION,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
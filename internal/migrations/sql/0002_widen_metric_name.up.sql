@@ -0,0 +1 @@
+ALTER TABLE metric ALTER COLUMN metric_name TYPE VARCHAR (255);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL,
+    action VARCHAR (20) NOT NULL,
+    source VARCHAR (64) NOT NULL,
+    token VARCHAR (255) NOT NULL,
+    agent_id VARCHAR (255) NOT NULL,
+    request_id VARCHAR (255) NOT NULL,
+    method VARCHAR (10) NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    count INTEGER NOT NULL,
+    names TEXT[] NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_at ON audit_log (at);
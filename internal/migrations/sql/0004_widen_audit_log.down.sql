@@ -0,0 +1,6 @@
+ALTER TABLE audit_log
+    ALTER COLUMN source TYPE VARCHAR (64) USING left(source, 64),
+    ALTER COLUMN token TYPE VARCHAR (255) USING left(token, 255),
+    ALTER COLUMN agent_id TYPE VARCHAR (255) USING left(agent_id, 255),
+    ALTER COLUMN request_id TYPE VARCHAR (255) USING left(request_id, 255),
+    ALTER COLUMN method TYPE VARCHAR (10) USING left(method, 10);
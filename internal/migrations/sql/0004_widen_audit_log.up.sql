@@ -0,0 +1,6 @@
+ALTER TABLE audit_log
+    ALTER COLUMN source TYPE TEXT,
+    ALTER COLUMN token TYPE TEXT,
+    ALTER COLUMN agent_id TYPE TEXT,
+    ALTER COLUMN request_id TYPE TEXT,
+    ALTER COLUMN method TYPE TEXT;
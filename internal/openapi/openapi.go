@@ -0,0 +1,27 @@
+package openapi
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Spec - returns OpenAPI 3 document describing http api of the server.
+func Spec() []byte {
+	return spec
+}
+
+// Handler - handler that routing from "/openapi.json".
+// Serving OpenAPI document.
+func Handler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, err := rw.Write(spec)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"log"
+)
+
+// UnaryServerInterceptor - rejects calls over limits with ResourceExhausted and "retry-after" header.
+// It must be chained after auth.UnaryServerInterceptor to tell clients apart by token.
+func (p Policy) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !p.Enabled() {
+			return handler(ctx, req)
+		}
+
+		token, ok := auth.FromContext(ctx)
+		wait := p.reserve(clientKey(token, ok, ipfilter.PeerIP(ctx).String()))
+		if wait > 0 {
+			err := grpc.SetHeader(ctx, metadata.Pairs("retry-after", RetryAfter(wait)))
+			if err != nil {
+				log.Println(err)
+			}
+			return nil, apierror.Status(codes.ResourceExhausted, apierror.CodeRateLimited, "rate limit exceeded")
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepInterval - how often buckets that refilled completely are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter - token buckets keyed by client. Each bucket holds up to burst tokens
+// and refills at rate tokens per second. Nil limiter or limiter with zero rate allows everything.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// New - creates limiter, burst less than 1 is raised to 1.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Enabled - reports whether limiter limits anything.
+func (l *Limiter) Enabled() bool {
+	return l != nil && l.rate > 0
+}
+
+// Reserve - takes token from bucket of key. Returns zero if token was taken,
+// otherwise time after which it will be available.
+func (l *Limiter) Reserve(key string) time.Duration {
+	if !l.Enabled() {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// Cancel - returns token taken by Reserve of key, e.g. when request was rejected by another limiter.
+func (l *Limiter) Cancel(key string) {
+	if !l.Enabled() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if ok {
+		b.tokens = math.Min(l.burst, b.tokens+1)
+	}
+}
+
+// sweep - drops buckets that are full again, they are indistinguishable from new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Policy - per client and global limits of ingestion requests.
+// Clients are told apart by token name, or by ip if request has no token.
+type Policy struct {
+	PerClient *Limiter
+	Global    *Limiter
+}
+
+// Enabled - reports whether policy limits anything.
+func (p Policy) Enabled() bool {
+	return p.PerClient.Enabled() || p.Global.Enabled()
+}
+
+// reserve - takes tokens of client and global limiters. Token of client is returned if global limit is exceeded,
+// so rejected requests don't drain client's bucket.
+func (p Policy) reserve(client string) time.Duration {
+	if wait := p.PerClient.Reserve(client); wait > 0 {
+		return wait
+	}
+	if wait := p.Global.Reserve(""); wait > 0 {
+		p.PerClient.Cancel(client)
+		return wait
+	}
+	return 0
+}
+
+func clientKey(token auth.Token, ok bool, ip string) string {
+	if ok {
+		return "token:" + token.Name
+	}
+	return "ip:" + ip
+}
+
+// RetryAfter - formats wait as value of Retry-After header, whole seconds rounded up.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// Middleware - rejects requests over limits with 429 and Retry-After header.
+// It must run after auth.Require and ipfilter.Resolver to tell clients apart.
+func (p Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			if !p.Enabled() {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			token, ok := auth.FromContext(r.Context())
+			wait := p.reserve(clientKey(token, ok, ipfilter.ClientIP(r).String()))
+			if wait > 0 {
+				rw.Header().Set("Retry-After", RetryAfter(wait))
+				apierror.Write(rw, r, http.StatusTooManyRequests, apierror.CodeRateLimited, "rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		},
+	)
+}
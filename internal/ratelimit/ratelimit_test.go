@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newLimiter(rate float64, burst int) (*Limiter, *clock) {
+	c := &clock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(rate, burst)
+	l.now = c.Now
+	return l, c
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+	l, c := newLimiter(2, 3)
+
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, l.Reserve("agent"), "burst request #%d", i)
+	}
+	assert.Equal(t, 500*time.Millisecond, l.Reserve("agent"))
+	assert.Zero(t, l.Reserve("other"), "buckets are per key")
+
+	c.now = c.now.Add(250 * time.Millisecond)
+	assert.Equal(t, 250*time.Millisecond, l.Reserve("agent"))
+
+	c.now = c.now.Add(250 * time.Millisecond)
+	assert.Zero(t, l.Reserve("agent"))
+
+	c.now = c.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, l.Reserve("agent"), "refill is capped by burst, request #%d", i)
+	}
+	assert.NotZero(t, l.Reserve("agent"))
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	l, c := newLimiter(1, 1)
+
+	l.Reserve("idle")
+	c.now = c.now.Add(2 * sweepInterval)
+	l.Reserve("active")
+
+	assert.NotContains(t, l.buckets, "idle")
+	assert.Contains(t, l.buckets, "active")
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	var l *Limiter
+	assert.Zero(t, l.Reserve("agent"))
+
+	l = New(0, 1)
+	for i := 0; i < 10; i++ {
+		assert.Zero(t, l.Reserve("agent"))
+	}
+}
+
+func TestPolicy_Middleware(t *testing.T) {
+	perClient, _ := newLimiter(1, 2)
+	global, _ := newLimiter(1, 3)
+	handler := Policy{PerClient: perClient, Global: global}.Middleware(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+	)
+
+	send := func(remote string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		request.RemoteAddr = remote
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	tests := []struct {
+		name       string
+		remote     string
+		statusCode int
+	}{
+		{name: "First agent", remote: "10.0.0.1:1000", statusCode: http.StatusOK},
+		{name: "First agent burst", remote: "10.0.0.1:1001", statusCode: http.StatusOK},
+		{name: "First agent over limit", remote: "10.0.0.1:1002", statusCode: http.StatusTooManyRequests},
+		{name: "Second agent", remote: "10.0.0.2:1000", statusCode: http.StatusOK},
+		{name: "Global limit", remote: "10.0.0.3:1000", statusCode: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		recorder := send(tt.remote)
+		require.Equal(t, tt.statusCode, recorder.Code, tt.name)
+		if tt.statusCode == http.StatusTooManyRequests {
+			assert.Equal(t, "1", recorder.Header().Get("Retry-After"), tt.name)
+		}
+	}
+}
+
+func TestPolicy_GlobalLimitKeepsClientToken(t *testing.T) {
+	perClient, _ := newLimiter(1, 2)
+	global, globalClock := newLimiter(1, 1)
+	p := Policy{PerClient: perClient, Global: global}
+
+	assert.Zero(t, p.reserve("other"))
+	assert.NotZero(t, p.reserve("agent"), "global limit is exceeded")
+
+	globalClock.now = globalClock.now.Add(time.Second)
+	assert.Zero(t, p.reserve("agent"))
+	globalClock.now = globalClock.now.Add(time.Second)
+	assert.Zero(t, p.reserve("agent"), "request rejected by global limit mustn't spend client's token")
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", RetryAfter(time.Millisecond))
+	assert.Equal(t, "2", RetryAfter(1500*time.Millisecond))
+	assert.Equal(t, "3", RetryAfter(3*time.Second))
+}
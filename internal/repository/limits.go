@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"sync"
+)
+
+var (
+	ErrBatchTooLarge = errors.New("too many metrics in batch")
+	ErrSeriesLimit   = errors.New("series limit exceeded")
+)
+
+type metricRepository interface {
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
+}
+
+// Limits - limits of LimitedStorage, zero means no limit.
+type Limits struct {
+	MaxBatch  int
+	MaxSeries int
+}
+
+// LimitedStorage - storage decorator that rejects too large batches and updates
+// that would create more distinct series than allowed. Series are counted by this
+// server only, so servers sharing database each enforce their own view of it.
+type LimitedStorage struct {
+	metricRepository
+	limits Limits
+
+	mutex  sync.Mutex
+	series map[string]struct{}
+}
+
+// NewLimitedStorage - wraps storage with limits.
+func NewLimitedStorage(storage metricRepository, limits Limits) *LimitedStorage {
+	return &LimitedStorage{metricRepository: storage, limits: limits}
+}
+
+func (ls *LimitedStorage) Update(ctx context.Context, metric metrics.Metric) error {
+	return ls.BatchUpdate(ctx, []metrics.Metric{metric})
+}
+
+func (ls *LimitedStorage) BatchUpdate(ctx context.Context, mtrcs []metrics.Metric) error {
+	if ls.limits.MaxBatch > 0 && len(mtrcs) > ls.limits.MaxBatch {
+		return fmt.Errorf("%w: %d > %d", ErrBatchTooLarge, len(mtrcs), ls.limits.MaxBatch)
+	}
+
+	added, err := ls.reserve(ctx, mtrcs)
+	if err != nil {
+		return err
+	}
+
+	err = ls.metricRepository.BatchUpdate(ctx, mtrcs)
+	if err != nil {
+		ls.release(added)
+		return err
+	}
+
+	return nil
+}
+
+// reserve - counts new series of batch in, returns them so they are released if update fails.
+func (ls *LimitedStorage) reserve(ctx context.Context, mtrcs []metrics.Metric) ([]string, error) {
+	if ls.limits.MaxSeries <= 0 {
+		return nil, nil
+	}
+
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if ls.series == nil {
+		stored, err := ls.metricRepository.GetMetricsMap(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ls.series = make(map[string]struct{}, len(stored))
+		for name := range stored {
+			ls.series[name] = struct{}{}
+		}
+	}
+
+	var added []string
+	for _, metric := range mtrcs {
+		if _, ok := ls.series[metric.GetName()]; ok {
+			continue
+		}
+
+		if len(ls.series) >= ls.limits.MaxSeries {
+			for _, name := range added {
+				delete(ls.series, name)
+			}
+			return nil, fmt.Errorf("metric %q: %w: %d", metric.GetName(), ErrSeriesLimit, ls.limits.MaxSeries)
+		}
+
+		ls.series[metric.GetName()] = struct{}{}
+		added = append(added, metric.GetName())
+	}
+
+	return added, nil
+}
+
+func (ls *LimitedStorage) release(names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	for _, name := range names {
+		delete(ls.series, name)
+	}
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type failingStorage struct {
+	*MemStorage
+	err error
+}
+
+func (fs *failingStorage) BatchUpdate(ctx context.Context, mtrcs []metrics.Metric) error {
+	if fs.err != nil {
+		return fs.err
+	}
+	return fs.MemStorage.BatchUpdate(ctx, mtrcs)
+}
+
+func TestLimitedStorage_BatchUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		stored  []metrics.Metric
+		batch   []metrics.Metric
+		wantErr error
+	}{
+		{
+			name:   "No limits",
+			limits: Limits{},
+			batch: []metrics.Metric{
+				metrics.NewMetricGauge("first", 1),
+				metrics.NewMetricGauge("second", 2),
+			},
+		},
+		{
+			name:   "Batch too large",
+			limits: Limits{MaxBatch: 1},
+			batch: []metrics.Metric{
+				metrics.NewMetricGauge("first", 1),
+				metrics.NewMetricGauge("second", 2),
+			},
+			wantErr: ErrBatchTooLarge,
+		},
+		{
+			name:   "Existing series don't count",
+			limits: Limits{MaxSeries: 2},
+			stored: []metrics.Metric{
+				metrics.NewMetricGauge("first", 1),
+				metrics.NewMetricGauge("second", 2),
+			},
+			batch: []metrics.Metric{
+				metrics.NewMetricGauge("first", 3),
+				metrics.NewMetricCounter("second", 4),
+			},
+		},
+		{
+			name:   "Series limit",
+			limits: Limits{MaxSeries: 2},
+			stored: []metrics.Metric{
+				metrics.NewMetricGauge("first", 1),
+			},
+			batch: []metrics.Metric{
+				metrics.NewMetricGauge("second", 2),
+				metrics.NewMetricGauge("third", 3),
+			},
+			wantErr: ErrSeriesLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemStorage()
+			require.NoError(t, storage.BatchUpdate(context.Background(), tt.stored))
+
+			ls := NewLimitedStorage(storage, tt.limits)
+			err := ls.BatchUpdate(context.Background(), tt.batch)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Len(t, storage.mtrcs, len(tt.stored), "rejected batch must not be stored")
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestLimitedStorage_Release(t *testing.T) {
+	storage := &failingStorage{MemStorage: NewMemStorage(), err: errors.New("connection refused")}
+	ls := NewLimitedStorage(storage, Limits{MaxSeries: 1})
+
+	err := ls.Update(context.Background(), metrics.NewMetricGauge("first", 1))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrSeriesLimit)
+
+	storage.err = nil
+	require.NoError(t, ls.Update(context.Background(), metrics.NewMetricGauge("second", 1)), "failed update must release series")
+	assert.ErrorIs(t, ls.Update(context.Background(), metrics.NewMetricGauge("third", 1)), ErrSeriesLimit)
+}
@@ -1,12 +1,18 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
-	"log"
 	"sync"
 )
 
+var (
+	ErrMetricNotFound  = errors.New("metric not found")
+	ErrUnsupportedKind = errors.New("unsupported metric kind")
+)
+
 // MemStorage - contains map of metrics where key is metrics name and value is metric.
 type MemStorage struct {
 	mutex sync.RWMutex
@@ -19,26 +25,58 @@ func NewMemStorage() *MemStorage {
 	}
 }
 
-func (ms *MemStorage) GetMetricsMap() map[string]metrics.Metric {
-	return ms.mtrcs
+// GetMetricsMap - returns copy of metrics, so it may be ranged over while storage is updated.
+func (ms *MemStorage) GetMetricsMap(_ context.Context) (map[string]metrics.Metric, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return ms.copyMetrics(), nil
+}
+
+// Drain - atomically returns copy of metrics and removes counters, so every increment is returned by exactly one drain.
+// Gauges keep their last values.
+func (ms *MemStorage) Drain(_ context.Context) (map[string]metrics.Metric, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	mtrcs := ms.copyMetrics()
+	for name, metric := range ms.mtrcs {
+		if metric.GetKind() == "counter" {
+			delete(ms.mtrcs, name)
+		}
+	}
+
+	return mtrcs, nil
+}
+
+func (ms *MemStorage) copyMetrics() map[string]metrics.Metric {
+	mtrcs := make(map[string]metrics.Metric, len(ms.mtrcs))
+	for name, metric := range ms.mtrcs {
+		mtrcs[name] = metric
+	}
+	return mtrcs
 }
 
-func (ms *MemStorage) GetMetric(name string) (metrics.Metric, error) {
+func (ms *MemStorage) GetMetric(_ context.Context, name string) (metrics.Metric, error) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
 
 	metric, ok := ms.mtrcs[name]
 	if !ok {
-		return metrics.Metric{}, errors.New("metric not found")
+		return metrics.Metric{}, ErrMetricNotFound
 	}
 
 	return metric, nil
 }
 
-func (ms *MemStorage) Update(newMetric metrics.Metric) {
+func (ms *MemStorage) Update(_ context.Context, newMetric metrics.Metric) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
+	return ms.update(newMetric)
+}
+
+func (ms *MemStorage) update(newMetric metrics.Metric) error {
 	switch newMetric.GetKind() {
 	case "gauge":
 		ms.mtrcs[newMetric.GetName()] = newMetric
@@ -53,12 +91,29 @@ func (ms *MemStorage) Update(newMetric metrics.Metric) {
 			ms.mtrcs[newMetric.GetName()] = newMetric
 		}
 	default:
-		log.Println("Error: not implemented!")
+		return fmt.Errorf("metric %q: %w", newMetric.GetName(), ErrUnsupportedKind)
 	}
+
+	return nil
 }
 
-func (ms *MemStorage) BatchUpdate(metrics []metrics.Metric) {
+// BatchUpdate - applies batch atomically: if any metric has unsupported kind, nothing is applied.
+func (ms *MemStorage) BatchUpdate(_ context.Context, metrics []metrics.Metric) error {
+	for _, metric := range metrics {
+		if metric.GetKind() != "gauge" && metric.GetKind() != "counter" {
+			return fmt.Errorf("metric %q: %w", metric.GetName(), ErrUnsupportedKind)
+		}
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
 	for _, metric := range metrics {
-		ms.Update(metric)
+		err := ms.update(metric)
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
@@ -1,8 +1,10 @@
 package repository
 
 import (
+	"context"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -42,7 +44,9 @@ func TestMemStorage_GetMetrics(t *testing.T) {
 			ms := &MemStorage{
 				mtrcs: tt.fields.mtrcs,
 			}
-			assert.Equal(t, tt.want, ms.GetMetricsMap())
+			got, err := ms.GetMetricsMap(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
 		})
 	}
 }
@@ -173,8 +177,37 @@ func TestMemStorage_Update(t *testing.T) {
 			ms := &MemStorage{
 				mtrcs: tt.fields.mtrcs,
 			}
-			ms.Update(tt.args.newMetric)
+			require.NoError(t, ms.Update(context.Background(), tt.args.newMetric))
 			assert.Equal(t, tt.want.mtrcs, ms.mtrcs)
 		})
 	}
 }
+
+func TestMemStorage_Drain(t *testing.T) {
+	ms := NewMemStorage()
+	require.NoError(t, ms.BatchUpdate(context.Background(), []metrics.Metric{
+		metrics.NewMetricCounter("PollCount", 2),
+		metrics.NewMetricGauge("Alloc", 1),
+	}))
+
+	snapshot, err := ms.GetMetricsMap(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, ms.Update(context.Background(), metrics.NewMetricCounter("PollCount", 1)))
+	counter := snapshot["PollCount"]
+	assert.Equal(t, metrics.Counter(2), counter.GetCounterValue(), "returned map must not be changed by updates")
+
+	drained, err := ms.Drain(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]metrics.Metric{
+		"PollCount": metrics.NewMetricCounter("PollCount", 3),
+		"Alloc":     metrics.NewMetricGauge("Alloc", 1),
+	}, drained)
+
+	require.NoError(t, ms.Update(context.Background(), metrics.NewMetricCounter("PollCount", 1)))
+	drained, err = ms.Drain(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]metrics.Metric{
+		"PollCount": metrics.NewMetricCounter("PollCount", 1),
+		"Alloc":     metrics.NewMetricGauge("Alloc", 1),
+	}, drained, "counters must accumulate increments since previous drain, gauges must be kept")
+}
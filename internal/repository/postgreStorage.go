@@ -1,25 +1,39 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/migrations"
 	_ "github.com/lib/pq"
-	"log"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 )
 
+// ErrKindMismatch - metric is stored with other kind, batch isn't applied.
+var ErrKindMismatch = errors.New("metric is stored with other kind")
+
 // PostgreStorage - contains pointer to pool of db connections.
 type PostgreStorage struct {
 	db *sql.DB
 }
 
-func NewPostgreStorage(db *sql.DB) *PostgreStorage {
-	storage := &PostgreStorage{db: db}
-	storage.ensureTableExists()
-	return storage
+// NewPostgreStorage - creates storage and applies pending schema migrations.
+func NewPostgreStorage(db *sql.DB) (*PostgreStorage, error) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgreStorage{db: db}, nil
 }
 
 type dbMetric struct {
@@ -29,272 +43,189 @@ type dbMetric struct {
 	value sql.NullFloat64
 }
 
-var _ sql.Scanner = &dbMetric{}
-
-func (metric *dbMetric) Scan(value any) error {
-	bytes, ok := value.([]byte)
-	if !ok {
-		log.Println("couldn't convert value to []byte")
-		return errors.New("couldn't convert value to []byte")
-	}
-
-	sv := string(bytes)
-	split := strings.Split(sv[1:len(sv)-1], ",")
-
-	metric.name = split[0]
-	metric.mType = split[1]
+func (metric *dbMetric) scan(row interface{ Scan(dest ...any) error }) error {
+	return row.Scan(&metric.name, &metric.mType, &metric.delta, &metric.value)
+}
 
+func (metric *dbMetric) toMetric() (metrics.Metric, error) {
 	switch metric.mType {
 	case "gauge":
-		value, err := strconv.ParseFloat(split[3], 64)
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		metric.value.Float64, metric.value.Valid = value, true
+		return metrics.NewMetricGauge(metric.name, metrics.Gauge(metric.value.Float64)), nil
 	case "counter":
-		delta, err := strconv.ParseInt(split[2], 10, 64)
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		metric.delta.Int64, metric.delta.Valid = delta, true
+		return metrics.NewMetricCounter(metric.name, metrics.Counter(metric.delta.Int64)), nil
 	default:
-		log.Println("not implemented")
-		return errors.New("not implemented")
+		return metrics.Metric{}, fmt.Errorf("metric %q: %w: %s", metric.name, ErrUnsupportedKind, metric.mType)
 	}
-
-	return nil
 }
 
-func (storage *PostgreStorage) GetMetricsMap() map[string]metrics.Metric {
-	metricsMap := make(map[string]metrics.Metric)
-
-	rows, err := storage.db.Query(`SELECT (metric_name, metric_type, metric_delta, metric_value) FROM metric`)
-	if err != nil || rows.Err() != nil {
-		return nil
+func (storage *PostgreStorage) GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error) {
+	rows, err := storage.db.QueryContext(ctx, `SELECT metric_name, metric_type, metric_delta, metric_value FROM metric`)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
+	metricsMap := make(map[string]metrics.Metric)
 	for rows.Next() {
 		var dbObj dbMetric
-		err = rows.Scan(&dbObj)
+		err = dbObj.scan(rows)
 		if err != nil {
-			return nil
+			return nil, err
 		}
 
-		var metric metrics.Metric
-		switch dbObj.mType {
-		case "gauge":
-			metric = metrics.NewMetricGauge(dbObj.name, metrics.Gauge(dbObj.value.Float64))
-		case "counter":
-			metric = metrics.NewMetricCounter(dbObj.name, metrics.Counter(dbObj.delta.Int64))
-		default:
-			log.Fatal("not implemented")
+		metric, err := dbObj.toMetric()
+		if err != nil {
+			return nil, err
 		}
 
 		metricsMap[metric.GetName()] = metric
 	}
 
-	return metricsMap
+	return metricsMap, rows.Err()
 }
 
-func (storage *PostgreStorage) GetMetric(name string) (metrics.Metric, error) {
-	row := storage.db.QueryRow(
-		`SELECT (metric_name, metric_type, metric_delta, metric_value) FROM metric WHERE metric_name = $1`,
+func (storage *PostgreStorage) GetMetric(ctx context.Context, name string) (metrics.Metric, error) {
+	row := storage.db.QueryRowContext(
+		ctx,
+		`SELECT metric_name, metric_type, metric_delta, metric_value FROM metric WHERE metric_name = $1`,
 		name,
 	)
-	if row.Err() != nil {
-		return metrics.Metric{}, row.Err()
-	}
 
 	var dbObj dbMetric
-	err := row.Scan(&dbObj)
+	err := dbObj.scan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return metrics.Metric{}, ErrMetricNotFound
+	}
 	if err != nil {
 		return metrics.Metric{}, err
 	}
 
-	var metric metrics.Metric
-	switch dbObj.mType {
-	case "gauge":
-		metric = metrics.NewMetricGauge(dbObj.name, metrics.Gauge(dbObj.value.Float64))
-	case "counter":
-		metric = metrics.NewMetricCounter(dbObj.name, metrics.Counter(dbObj.delta.Int64))
-	default:
-		log.Fatal("not implemented")
-	}
-
-	return metric, nil
+	return dbObj.toMetric()
 }
 
-func (storage *PostgreStorage) Update(metric metrics.Metric) {
-	row := storage.db.QueryRow(`SELECT COUNT(*) FROM metric WHERE metric_name = $1`, metric.GetName())
-	if row.Err() != nil {
-		return
-	}
-
-	var count int
-	err := row.Scan(&count)
-	if err != nil {
-		return
-	}
+const (
+	// upsertQuery - inserts metrics or merges them with existing ones: gauges are replaced, counters are incremented.
+	// Gauges have NULL delta and counters have NULL value, so one statement serves both kinds. Rows of other kind
+	// aren't updated, so they aren't counted in affected rows.
+	upsertQuery = `INSERT INTO metric (metric_name, metric_type, metric_delta, metric_value, created_at, updated_at)
+VALUES %s
+ON CONFLICT (metric_name) DO UPDATE SET
+    metric_delta = COALESCE(metric.metric_delta + EXCLUDED.metric_delta, metric.metric_delta),
+    metric_value = COALESCE(EXCLUDED.metric_value, metric.metric_value),
+    updated_at = EXCLUDED.updated_at
+WHERE metric.metric_type = EXCLUDED.metric_type`
+	upsertParams = 5
+	// upsertBatchSize - max rows in one statement, keeps params count far below postgres limit of 65535.
+	upsertBatchSize = 1000
+)
 
-	if count == 0 {
-		storage.insertMetric(metric)
-		return
-	}
-	storage.updateMetric(metric)
+func (storage *PostgreStorage) Update(ctx context.Context, metric metrics.Metric) error {
+	return storage.BatchUpdate(ctx, []metrics.Metric{metric})
 }
 
-func (storage *PostgreStorage) insertMetric(metric metrics.Metric) {
-	switch metric.GetKind() {
-	case "gauge":
-		_, _ = storage.db.Exec(
-			`INSERT INTO metric (metric_name, metric_type, metric_value, created_at, updated_at) 
-					VALUES ($1, 'gauge', $2, $3, $4)`,
-			metric.GetName(),
-			metric.GetGaugeValue(),
-			time.Now(),
-			time.Now(),
-		)
-	case "counter":
-		_, _ = storage.db.Exec(
-			`INSERT INTO metric (metric_name, metric_type, metric_delta, created_at, updated_at) 
-					VALUES ($1, 'counter', $2, $3, $4)`,
-			metric.GetName(),
-			metric.GetCounterValue(),
-			time.Now(),
-			time.Now(),
-		)
-	default:
-		log.Fatal("not implemented")
+// BatchUpdate - upserts batch in one transaction. Metric can't change its kind: if any metric is stored
+// with other kind, nothing is applied and ErrKindMismatch is returned.
+func (storage *PostgreStorage) BatchUpdate(ctx context.Context, metrics []metrics.Metric) error {
+	merged, err := mergeMetrics(metrics)
+	if err != nil {
+		return err
 	}
-}
-
-func (storage *PostgreStorage) updateMetric(metric metrics.Metric) {
-	switch metric.GetKind() {
-	case "gauge":
-		_, _ = storage.db.Exec(
-			`UPDATE metric SET metric_value=$1, updated_at=$2 WHERE metric_name=$3`,
-			metric.GetGaugeValue(),
-			time.Now(),
-			metric.GetName(),
-		)
-	case "counter":
-		_, _ = storage.db.Exec(
-			`UPDATE metric SET metric_delta=metric_delta+$1, updated_at=$2 WHERE metric_name=$3`,
-			metric.GetCounterValue(),
-			time.Now(),
-			metric.GetName(),
-		)
-	default:
-		log.Fatal("not implemented")
+	if len(merged) == 0 {
+		return nil
 	}
-}
 
-const tableCreation string = `CREATE TABLE IF NOT EXISTS metric (
-    metric_name VARCHAR (50) PRIMARY KEY, 
-    metric_type VARCHAR (10) NOT NULL, 
-    metric_delta BIGINT, 
-    metric_value DOUBLE PRECISION, 
-    created_at TIMESTAMP NOT NULL, 
-    updated_at TIMESTAMP NOT NULL
-);`
-
-func (storage *PostgreStorage) ensureTableExists() {
-	_, _ = storage.db.Exec(tableCreation)
-}
+	now := time.Now()
 
-func (storage *PostgreStorage) BatchUpdate(metrics []metrics.Metric) {
-	tx, err := storage.db.Begin()
+	tx, err := storage.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 	defer tx.Rollback()
 
-	selectStmt, err := tx.Prepare(`SELECT COUNT(*) FROM metric WHERE metric_name = $1`)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	for start := 0; start < len(merged); start += upsertBatchSize {
+		end := start + upsertBatchSize
+		if end > len(merged) {
+			end = len(merged)
+		}
 
-	insertGaugeStmt, err := tx.Prepare(`INSERT INTO metric (metric_name, metric_type, metric_value, created_at, updated_at) 
-												VALUES ($1, 'gauge', $2, $3, $4)`)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+		query, args := buildUpsert(merged[start:end], now)
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
 
-	insertCounterStmt, err := tx.Prepare(`INSERT INTO metric (metric_name, metric_type, metric_delta, created_at, updated_at) 
-									VALUES ($1, 'counter', $2, $3, $4)`)
-	if err != nil {
-		log.Println(err)
-		return
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected != int64(end-start) {
+			return ErrKindMismatch
+		}
 	}
 
-	updateGaugeStmt, err := tx.Prepare(
-		`UPDATE metric SET metric_value=$1, updated_at=$2 WHERE metric_name=$3`,
-	)
-	if err != nil {
-		log.Println(err)
-		return
+	return tx.Commit()
+}
+
+// mergeMetrics - collapses metrics with the same name, because one statement can't update a row twice.
+// Counters are summed, the last gauge wins. Result is sorted by name, so concurrent batches lock rows
+// in the same order and don't deadlock.
+func mergeMetrics(mtrcs []metrics.Metric) ([]metrics.Metric, error) {
+	byName := make(map[string]metrics.Metric, len(mtrcs))
+	for _, metric := range mtrcs {
+		switch metric.GetKind() {
+		case "gauge":
+			byName[metric.GetName()] = metric
+		case "counter":
+			prev, ok := byName[metric.GetName()]
+			if ok && prev.GetKind() == "counter" {
+				metric = metrics.NewMetricCounter(
+					metric.GetName(),
+					prev.GetCounterValue()+metric.GetCounterValue(),
+				)
+			}
+			byName[metric.GetName()] = metric
+		default:
+			return nil, fmt.Errorf("metric %q: %w", metric.GetName(), ErrUnsupportedKind)
+		}
 	}
 
-	updateCounterStmt, err := tx.Prepare(
-		`UPDATE metric SET metric_delta=metric_delta+$1, updated_at=$2 WHERE metric_name=$3`,
-	)
-	if err != nil {
-		log.Println(err)
-		return
+	merged := make([]metrics.Metric, 0, len(byName))
+	for _, metric := range byName {
+		merged = append(merged, metric)
 	}
 
-	for _, metric := range metrics {
-		row := selectStmt.QueryRow(metric.GetName())
-		if row.Err() != nil {
-			log.Println(err)
-			return
-		}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].GetName() < merged[j].GetName()
+	})
 
-		var count int
-		err = row.Scan(&count)
-		if err != nil {
-			log.Println(err)
-			return
+	return merged, nil
+}
+
+// buildUpsert - returns multi-row upsert statement and its args.
+func buildUpsert(mtrcs []metrics.Metric, now time.Time) (string, []any) {
+	var values strings.Builder
+	args := make([]any, 0, len(mtrcs)*upsertParams)
+
+	for i, metric := range mtrcs {
+		if i > 0 {
+			values.WriteString(", ")
 		}
+		n := i * upsertParams
+		fmt.Fprintf(&values, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+5)
 
+		var (
+			delta sql.NullInt64
+			value sql.NullFloat64
+		)
 		switch metric.GetKind() {
 		case "gauge":
-			if count == 0 {
-				_, err = insertGaugeStmt.Exec(metric.GetName(), metric.GetGaugeValue(), time.Now(), time.Now())
-				if err != nil {
-					log.Println(err)
-					return
-				}
-			} else {
-				_, err = updateGaugeStmt.Exec(metric.GetGaugeValue(), time.Now(), metric.GetName())
-				if err != nil {
-					log.Println(err)
-					return
-				}
-			}
+			value = sql.NullFloat64{Float64: float64(metric.GetGaugeValue()), Valid: true}
 		case "counter":
-			if count == 0 {
-				_, err = insertCounterStmt.Exec(metric.GetName(), metric.GetCounterValue(), time.Now(), time.Now())
-				if err != nil {
-					log.Println(err)
-					return
-				}
-			} else {
-				_, err = updateCounterStmt.Exec(metric.GetCounterValue(), time.Now(), metric.GetName())
-				if err != nil {
-					log.Println(err)
-					return
-				}
-			}
-		default:
-			log.Fatal("not implemented")
+			delta = sql.NullInt64{Int64: int64(metric.GetCounterValue()), Valid: true}
 		}
+
+		args = append(args, metric.GetName(), metric.GetKind(), delta, value, now)
 	}
 
-	tx.Commit()
+	return fmt.Sprintf(upsertQuery, values.String()), args
 }
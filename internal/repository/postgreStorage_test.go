@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMergeMetrics(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []metrics.Metric
+		want []metrics.Metric
+	}{
+		{
+			name: "Empty batch",
+			in:   nil,
+			want: []metrics.Metric{},
+		},
+		{
+			name: "Counters are summed, last gauge wins",
+			in: []metrics.Metric{
+				metrics.NewMetricGauge("b", 1),
+				metrics.NewMetricCounter("a", 2),
+				metrics.NewMetricGauge("b", 3),
+				metrics.NewMetricCounter("a", 5),
+				metrics.NewMetricCounter("c", 7),
+			},
+			want: []metrics.Metric{
+				metrics.NewMetricCounter("a", 7),
+				metrics.NewMetricGauge("b", 3),
+				metrics.NewMetricCounter("c", 7),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeMetrics(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuildUpsert(t *testing.T) {
+	now := time.Now()
+	query, args := buildUpsert(
+		[]metrics.Metric{
+			metrics.NewMetricCounter("a", 2),
+			metrics.NewMetricGauge("b", 1.5),
+		},
+		now,
+	)
+
+	assert.Contains(t, query, "VALUES ($1, $2, $3, $4, $5, $5), ($6, $7, $8, $9, $10, $10)")
+	assert.Contains(t, query, "ON CONFLICT (metric_name) DO UPDATE")
+	assert.Contains(t, query, "WHERE metric.metric_type = EXCLUDED.metric_type", "metric mustn't change its kind")
+	assert.Equal(t, []any{
+		"a", "counter", sql.NullInt64{Int64: 2, Valid: true}, sql.NullFloat64{}, now,
+		"b", "gauge", sql.NullInt64{}, sql.NullFloat64{Float64: 1.5, Valid: true}, now,
+	}, args)
+}
+
+func TestPostgreStorage_BatchUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		batch    []metrics.Metric
+		affected []int64
+		wantErr  error
+	}{
+		{
+			name: "All rows are upserted",
+			batch: []metrics.Metric{
+				metrics.NewMetricCounter("a", 1),
+				metrics.NewMetricGauge("b", 1),
+			},
+			affected: []int64{2},
+		},
+		{
+			name: "Metric is stored with other kind",
+			batch: []metrics.Metric{
+				metrics.NewMetricCounter("a", 1),
+				metrics.NewMetricGauge("b", 1),
+			},
+			affected: []int64{1},
+			wantErr:  ErrKindMismatch,
+		},
+		{
+			name:     "Mismatch in the last chunk rolls back previous chunks",
+			batch:    counterBatch(upsertBatchSize + 1),
+			affected: []int64{upsertBatchSize, 0},
+			wantErr:  ErrKindMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			for _, affected := range tt.affected {
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO metric")).
+					WillReturnResult(sqlmock.NewResult(0, affected))
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			storage := &PostgreStorage{db: db}
+			err = storage.BatchUpdate(context.Background(), tt.batch)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func counterBatch(n int) []metrics.Metric {
+	batch := make([]metrics.Metric, 0, n)
+	for i := 0; i < n; i++ {
+		batch = append(batch, metrics.NewMetricCounter(fmt.Sprintf("counter%d", i), 1))
+	}
+	return batch
+}
+
+// BenchmarkPostgreStorage_BatchUpdate - compares upsert writes with previous "select, then insert or update" path.
+// Requires running postgres: TEST_DATABASE_DSN="postgres://..." go test -bench=BatchUpdate ./internal/repository
+func BenchmarkPostgreStorage_BatchUpdate(b *testing.B) {
+	dsn, ok := os.LookupEnv("TEST_DATABASE_DSN")
+	if !ok {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(b, err)
+	defer db.Close()
+
+	storage, err := NewPostgreStorage(db)
+	require.NoError(b, err)
+
+	batch := make([]metrics.Metric, 0, 100)
+	for i := 0; i < 50; i++ {
+		batch = append(batch,
+			metrics.NewMetricGauge(fmt.Sprintf("benchGauge%d", i), metrics.Gauge(i)),
+			metrics.NewMetricCounter(fmt.Sprintf("benchCounter%d", i), metrics.Counter(i)),
+		)
+	}
+
+	b.Run("upsert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, storage.BatchUpdate(context.Background(), batch))
+		}
+	})
+
+	b.Run("select-then-write", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, legacyBatchUpdate(db, batch))
+		}
+	})
+}
+
+// legacyBatchUpdate - previous implementation of BatchUpdate, kept as a benchmark baseline.
+func legacyBatchUpdate(db *sql.DB, mtrcs []metrics.Metric) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, metric := range mtrcs {
+		var count int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM metric WHERE metric_name = $1`, metric.GetName()).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case metric.GetKind() == "gauge" && count == 0:
+			_, err = tx.Exec(
+				`INSERT INTO metric (metric_name, metric_type, metric_value, created_at, updated_at) VALUES ($1, 'gauge', $2, $3, $4)`,
+				metric.GetName(), metric.GetGaugeValue(), time.Now(), time.Now(),
+			)
+		case metric.GetKind() == "gauge":
+			_, err = tx.Exec(
+				`UPDATE metric SET metric_value=$1, updated_at=$2 WHERE metric_name=$3`,
+				metric.GetGaugeValue(), time.Now(), metric.GetName(),
+			)
+		case count == 0:
+			_, err = tx.Exec(
+				`INSERT INTO metric (metric_name, metric_type, metric_delta, created_at, updated_at) VALUES ($1, 'counter', $2, $3, $4)`,
+				metric.GetName(), metric.GetCounterValue(), time.Now(), time.Now(),
+			)
+		default:
+			_, err = tx.Exec(
+				`UPDATE metric SET metric_delta=metric_delta+$1, updated_at=$2 WHERE metric_name=$3`,
+				metric.GetCounterValue(), time.Now(), metric.GetName(),
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
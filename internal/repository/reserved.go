@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"strings"
+)
+
+var ErrReservedName = errors.New("metric name is reserved")
+
+// ReservedStorage - storage decorator that rejects batches with metrics named with reserved prefix,
+// so metrics of untrusted sources can't be mistaken for metrics written by owner of the prefix.
+type ReservedStorage struct {
+	metricRepository
+	prefix string
+}
+
+// NewReservedStorage - wraps storage with reserved prefix.
+func NewReservedStorage(storage metricRepository, prefix string) *ReservedStorage {
+	return &ReservedStorage{metricRepository: storage, prefix: prefix}
+}
+
+func (rs *ReservedStorage) Update(ctx context.Context, metric metrics.Metric) error {
+	return rs.BatchUpdate(ctx, []metrics.Metric{metric})
+}
+
+func (rs *ReservedStorage) BatchUpdate(ctx context.Context, mtrcs []metrics.Metric) error {
+	for _, metric := range mtrcs {
+		if strings.HasPrefix(metric.GetName(), rs.prefix) {
+			return fmt.Errorf("metric %q: %w: prefix %q", metric.GetName(), ErrReservedName, rs.prefix)
+		}
+	}
+
+	return rs.metricRepository.BatchUpdate(ctx, mtrcs)
+}
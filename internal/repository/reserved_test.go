@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestReservedStorage_BatchUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   []metrics.Metric
+		wantErr error
+		stored  int
+	}{
+		{
+			name:   "Not reserved",
+			batch:  []metrics.Metric{metrics.NewMetricGauge("Alloc", 1), metrics.NewMetricCounter("agentRuns", 1)},
+			stored: 2,
+		},
+		{
+			name:    "Reserved name rejects whole batch",
+			batch:   []metrics.Metric{metrics.NewMetricGauge("Alloc", 1), metrics.NewMetricCounter("agent_uploads_total", 1)},
+			wantErr: ErrReservedName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := NewMemStorage()
+			rs := NewReservedStorage(ms, "agent_")
+
+			err := rs.BatchUpdate(context.Background(), tt.batch)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			stored, err := ms.GetMetricsMap(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, stored, tt.stored)
+		})
+	}
+}
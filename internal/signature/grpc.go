@@ -0,0 +1,93 @@
+package signature
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"strings"
+)
+
+// grpcMethod - gRPC requests are signed as POST to full method name, like http requests to path.
+const grpcMethod = "POST"
+
+// marshal - signed body of gRPC request is its deterministic protobuf encoding.
+func marshal(req interface{}) ([]byte, error) {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.Internal, "request is not a protobuf message")
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
+
+func fromMetadata(md metadata.MD, key string) string {
+	values := md.Get(strings.ToLower(key))
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// UnaryServerInterceptor - verifies signature passed in request metadata.
+func (v *Verifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		f := Fields{
+			Signature: fromMetadata(md, Header),
+			Timestamp: fromMetadata(md, TimestampHeader),
+			Nonce:     fromMetadata(md, NonceHeader),
+			KeyID:     fromMetadata(md, KeyIDHeader),
+			Algorithm: fromMetadata(md, AlgorithmHeader),
+		}
+
+		body, err := marshal(req)
+		if err != nil {
+			return nil, err
+		}
+
+		verified, err := v.Verify(f, grpcMethod, info.FullMethod, body)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		if verified {
+			ctx = context.WithValue(ctx, verifiedKey{}, true)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// UnaryClientInterceptor - signs outgoing requests with the current signing key of keyring.
+func UnaryClientInterceptor(keys *hash.Keyring, alg hash.Algorithm) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := marshal(req)
+		if err != nil {
+			return err
+		}
+
+		f, err := New(keys, alg, grpcMethod, method, body)
+		if err != nil {
+			return err
+		}
+
+		pairs := []string{
+			strings.ToLower(Header), f.Signature,
+			strings.ToLower(TimestampHeader), f.Timestamp,
+			strings.ToLower(NonceHeader), f.Nonce,
+		}
+		if f.KeyID != "" {
+			pairs = append(pairs, strings.ToLower(KeyIDHeader), f.KeyID)
+		}
+		if f.Algorithm != "" {
+			pairs = append(pairs, strings.ToLower(AlgorithmHeader), f.Algorithm)
+		}
+
+		ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,234 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of signed request.
+const (
+	Header          = "X-Signature"
+	TimestampHeader = "X-Signature-Timestamp"
+	NonceHeader     = "X-Signature-Nonce"
+	KeyIDHeader     = "X-Signature-Key-ID"
+	// AlgorithmHeader - declared HMAC algorithm, HMAC-SHA256 is used if it is absent.
+	AlgorithmHeader = "X-Signature-Algorithm"
+
+	// DefaultWindow - how far request timestamp may be from server clock.
+	DefaultWindow = 5 * time.Minute
+
+	nonceSize    = 16
+	maxNonceSize = 128
+)
+
+var (
+	ErrUnsigned = errors.New("request is not signed")
+	ErrInvalid  = errors.New("invalid request signature")
+	ErrExpired  = errors.New("request timestamp is outside of replay window")
+	ErrReplayed = errors.New("request nonce was already used")
+)
+
+// Fields - signature of one request.
+type Fields struct {
+	Signature string
+	Timestamp string
+	Nonce     string
+	KeyID     string
+	Algorithm string
+}
+
+func (f Fields) empty() bool {
+	return f.Signature == "" && f.Timestamp == "" && f.Nonce == "" && f.KeyID == "" && f.Algorithm == ""
+}
+
+// payload - canonical data covered by signature. Body is hashed so payload stays small for big batches.
+func payload(method, path, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(strings.Join([]string{method, path, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n"))
+}
+
+// New - signs body of request to method and path with the current signing key of keyring.
+func New(keys *hash.Keyring, alg hash.Algorithm, method, path string, body []byte) (Fields, error) {
+	nonce := make([]byte, nonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return Fields{}, err
+	}
+
+	f := Fields{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     hex.EncodeToString(nonce),
+		Algorithm: string(alg),
+	}
+
+	f.Signature, f.KeyID, err = keys.Sign(alg, payload(method, path, f.Timestamp, f.Nonce, body))
+	if err != nil {
+		return Fields{}, err
+	}
+
+	return f, nil
+}
+
+// Sign - signs http request with body. Body is signed before compression and encryption.
+func Sign(req *http.Request, body []byte, keys *hash.Keyring, alg hash.Algorithm) error {
+	f, err := New(keys, alg, req.Method, req.URL.EscapedPath(), body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(Header, f.Signature)
+	req.Header.Set(TimestampHeader, f.Timestamp)
+	req.Header.Set(NonceHeader, f.Nonce)
+	if f.KeyID != "" {
+		req.Header.Set(KeyIDHeader, f.KeyID)
+	}
+	if f.Algorithm != "" {
+		req.Header.Set(AlgorithmHeader, f.Algorithm)
+	}
+
+	return nil
+}
+
+type verifiedKey struct{}
+
+// Verified - reports whether request of ctx has valid request-level signature,
+// so per-metric hashes don't need to be checked.
+func Verified(ctx context.Context) bool {
+	verified, _ := ctx.Value(verifiedKey{}).(bool)
+	return verified
+}
+
+// Verifier - checks request signatures and remembers nonces to reject replayed requests.
+type Verifier struct {
+	keys   *hash.Keyring
+	window time.Duration
+	strict bool
+	now    func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewVerifier - creates verifier. In strict mode unsigned requests are rejected,
+// otherwise they are passed to handlers that check per-metric hashes.
+func NewVerifier(keys *hash.Keyring, window time.Duration, strict bool) *Verifier {
+	return &Verifier{
+		keys:   keys,
+		window: window,
+		strict: strict,
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Verify - checks signature of body sent to method and path. Returns false without error for
+// unsigned request, if verifier isn't strict.
+func (v *Verifier) Verify(f Fields, method, path string, body []byte) (bool, error) {
+	if f.empty() {
+		if v.strict {
+			return false, ErrUnsigned
+		}
+		return false, nil
+	}
+
+	if f.Signature == "" || f.Timestamp == "" || len(f.Nonce) < nonceSize || len(f.Nonce) > maxNonceSize {
+		return false, ErrInvalid
+	}
+
+	alg, err := hash.ParseAlgorithm(f.Algorithm)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+
+	unix, err := strconv.ParseInt(f.Timestamp, 10, 64)
+	if err != nil {
+		return false, ErrInvalid
+	}
+
+	now := v.now()
+	timestamp := time.Unix(unix, 0)
+	if timestamp.Before(now.Add(-v.window)) || timestamp.After(now.Add(v.window)) {
+		return false, ErrExpired
+	}
+
+	if !v.keys.Valid(alg, f.Signature, payload(method, path, f.Timestamp, f.Nonce, body), f.KeyID) {
+		return false, ErrInvalid
+	}
+
+	// Nonce is remembered only for valid signatures, so forged requests can't fill the cache.
+	if !v.remember(f.Nonce, now) {
+		return false, ErrReplayed
+	}
+
+	return true, nil
+}
+
+// remember - stores nonce till it can't pass timestamp check, returns false if nonce is already stored.
+func (v *Verifier) remember(nonce string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.After(v.nextSweep) {
+		for n, expiry := range v.nonces {
+			if now.After(expiry) {
+				delete(v.nonces, n)
+			}
+		}
+		v.nextSweep = now.Add(v.window)
+	}
+
+	if expiry, ok := v.nonces[nonce]; ok && !now.After(expiry) {
+		return false
+	}
+
+	v.nonces[nonce] = now.Add(2 * v.window)
+	return true
+}
+
+// Middleware - verifies signature of request body, so it goes after decryption and decompression.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				apierror.WriteBodyError(rw, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			f := Fields{
+				Signature: r.Header.Get(Header),
+				Timestamp: r.Header.Get(TimestampHeader),
+				Nonce:     r.Header.Get(NonceHeader),
+				KeyID:     r.Header.Get(KeyIDHeader),
+				Algorithm: r.Header.Get(AlgorithmHeader),
+			}
+
+			verified, err := v.Verify(f, r.Method, r.URL.EscapedPath(), body)
+			if err != nil {
+				apierror.Write(rw, r, http.StatusUnauthorized, apierror.CodeInvalidSignature, err.Error())
+				return
+			}
+
+			if verified {
+				r = r.WithContext(context.WithValue(r.Context(), verifiedKey{}, true))
+			}
+
+			next.ServeHTTP(rw, r)
+		},
+	)
+}
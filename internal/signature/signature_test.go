@@ -0,0 +1,259 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/testutil"
+	"github.com/VladimirMovsesyan/praktikum-devops/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testKey = hash.Key{ID: "current", Secret: "superSecretKey"}
+
+func TestVerifier_Verify(t *testing.T) {
+	keys := testutil.Keyring(t, testKey)
+	body := []byte(`[{"id":"Alloc","type":"gauge","value":123.456789012345}]`)
+
+	signed := func(t *testing.T) Fields {
+		f, err := New(keys, hash.SHA512, http.MethodPost, "/updates/", body)
+		require.NoError(t, err)
+		return f
+	}
+
+	tests := []struct {
+		name         string
+		fields       func(t *testing.T) Fields
+		path         string
+		body         []byte
+		strict       bool
+		wantVerified bool
+		wantErr      error
+	}{
+		{
+			name:         "Signed request",
+			fields:       signed,
+			wantVerified: true,
+		},
+		{
+			name:    "Tampered body",
+			fields:  signed,
+			body:    []byte(`[{"id":"Alloc","type":"gauge","value":123.456789012346}]`),
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Other path",
+			fields:  signed,
+			path:    "/update/",
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "Unknown key",
+			fields: func(t *testing.T) Fields {
+				f := signed(t)
+				f.KeyID = "unknown"
+				return f
+			},
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "Other algorithm",
+			fields: func(t *testing.T) Fields {
+				f := signed(t)
+				f.Algorithm = string(hash.SHA256)
+				return f
+			},
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "Unsupported algorithm",
+			fields: func(t *testing.T) Fields {
+				f := signed(t)
+				f.Algorithm = "hmac-md5"
+				return f
+			},
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "Short nonce",
+			fields: func(t *testing.T) Fields {
+				f := signed(t)
+				f.Nonce = "1"
+				return f
+			},
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "Stale timestamp",
+			fields: func(t *testing.T) Fields {
+				f := signed(t)
+				f.Timestamp = strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+				return f
+			},
+			wantErr: ErrExpired,
+		},
+		{
+			name: "Future timestamp",
+			fields: func(t *testing.T) Fields {
+				f := signed(t)
+				f.Timestamp = strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+				return f
+			},
+			wantErr: ErrExpired,
+		},
+		{
+			name:   "Unsigned request",
+			fields: func(t *testing.T) Fields { return Fields{} },
+		},
+		{
+			name:    "Unsigned request in strict mode",
+			fields:  func(t *testing.T) Fields { return Fields{} },
+			strict:  true,
+			wantErr: ErrUnsigned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(keys, DefaultWindow, tt.strict)
+
+			path := "/updates/"
+			if tt.path != "" {
+				path = tt.path
+			}
+			reqBody := body
+			if tt.body != nil {
+				reqBody = tt.body
+			}
+
+			verified, err := v.Verify(tt.fields(t), http.MethodPost, path, reqBody)
+			require.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantVerified, verified)
+		})
+	}
+}
+
+func TestVerifier_Replay(t *testing.T) {
+	keys := testutil.Keyring(t, testKey)
+	v := NewVerifier(keys, time.Minute, false)
+
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	f, err := New(keys, hash.Legacy, http.MethodPost, "/updates/", nil)
+	require.NoError(t, err)
+
+	_, err = v.Verify(f, http.MethodPost, "/updates/", nil)
+	require.NoError(t, err)
+
+	_, err = v.Verify(f, http.MethodPost, "/updates/", nil)
+	require.ErrorIs(t, err, ErrReplayed)
+
+	// Once nonce is expired, replay is rejected by timestamp and nonce is swept.
+	now = now.Add(3 * time.Minute)
+	_, err = v.Verify(f, http.MethodPost, "/updates/", nil)
+	require.ErrorIs(t, err, ErrExpired)
+
+	assert.True(t, v.remember("other", now))
+	assert.Len(t, v.nonces, 1)
+}
+
+func TestVerifier_Middleware(t *testing.T) {
+	keys := testutil.Keyring(t, testKey)
+	v := NewVerifier(keys, DefaultWindow, false)
+
+	var verified bool
+	var received []byte
+	handler := v.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		verified = Verified(r.Context())
+		received, _ = io.ReadAll(r.Body)
+	}))
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	signed := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	require.NoError(t, Sign(signed, body, keys, hash.SHA256))
+
+	send := func() *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		request.Header = signed.Header.Clone()
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	result := send()
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.True(t, verified)
+	assert.Equal(t, body, received)
+
+	replayed := send()
+	defer replayed.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, replayed.StatusCode)
+}
+
+type metricsServer struct {
+	proto.UnimplementedMetricsCollectionServer
+	verified bool
+}
+
+func (s *metricsServer) UpdateMetrics(ctx context.Context, _ *proto.BatchUpdateMetricsRequest) (*proto.BatchUpdateMetricsResponse, error) {
+	s.verified = Verified(ctx)
+	return &proto.BatchUpdateMetricsResponse{}, nil
+}
+
+func TestUnaryInterceptors(t *testing.T) {
+	keys := testutil.Keyring(t, testKey)
+	v := NewVerifier(keys, DefaultWindow, true)
+
+	service := &metricsServer{}
+	server := grpc.NewServer(grpc.UnaryInterceptor(v.UnaryServerInterceptor()))
+	proto.RegisterMetricsCollectionServer(server, service)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	request := &proto.BatchUpdateMetricsRequest{
+		Metrics: []*proto.Metrics{{ID: "Alloc", MType: proto.Metrics_GAUGE, Value: 1.5}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Signed request", func(t *testing.T) {
+		conn, err := grpc.Dial(
+			listener.Addr().String(),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(UnaryClientInterceptor(keys, hash.SHA512)),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = proto.NewMetricsCollectionClient(conn).UpdateMetrics(ctx, request)
+		require.NoError(t, err)
+		assert.True(t, service.verified)
+	})
+
+	t.Run("Unsigned request", func(t *testing.T) {
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = proto.NewMetricsCollectionClient(conn).UpdateMetrics(ctx, request)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
@@ -0,0 +1,253 @@
+// Package telemetry - metrics of agent about itself. They are named with reserved Prefix, uploaded with
+// collected metrics and exposed on agent's local endpoints, so broken agent can be told from dead host:
+// server receives telemetry of agent which can't collect, and local health check fails when agent can't upload.
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix - reserved prefix of telemetry, metrics with it are rejected from collectors and local ingestion.
+const Prefix = "agent_"
+
+// Names of telemetry, collectors and tasks are named by their own metrics.
+const (
+	UploadDuration  = Prefix + "upload_duration_seconds"
+	Uploads         = Prefix + "uploads_total"
+	UploadFailures  = Prefix + "upload_failures_total"
+	SentBytes       = Prefix + "sent_bytes_total"
+	BufferedMetrics = Prefix + "buffered_metrics"
+	QueuedTasks     = Prefix + "queued_tasks"
+)
+
+// unhealthyFailures - number of consecutive failed uploads which makes agent unhealthy.
+const unhealthyFailures = 3
+
+// Statuses of health check.
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// CollectDuration - returns name of gauge of collector's poll duration.
+func CollectDuration(collector string) string {
+	return Prefix + "collector_" + collector + "_duration_seconds"
+}
+
+// CollectFailures - returns name of counter of collector's failed polls.
+func CollectFailures(collector string) string {
+	return Prefix + "collector_" + collector + "_failures_total"
+}
+
+// DroppedTasks - returns name of counter of dropped tasks.
+func DroppedTasks(task string) string {
+	return Prefix + "dropped_" + task + "_tasks_total"
+}
+
+type recorder interface {
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
+}
+
+// Health - result of agent's health check.
+type Health struct {
+	Status              string     `json:"status"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// Telemetry - records telemetry to buffer of uploaded metrics and keeps its totals for local endpoints.
+// It is safe for concurrent use.
+type Telemetry struct {
+	buffer recorder
+	totals *repository.MemStorage
+	now    func() time.Time
+
+	mu          sync.Mutex
+	registered  map[string]struct{}
+	sent        uint64
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	failures    int
+}
+
+// New - creates telemetry recorded to buffer.
+func New(buffer recorder) *Telemetry {
+	return &Telemetry{
+		buffer:     buffer,
+		totals:     repository.NewMemStorage(),
+		now:        time.Now,
+		registered: make(map[string]struct{}),
+	}
+}
+
+// counter - returns increment of counter. Zero increment is returned only the first time, so counter is known
+// to server and local endpoint before it grows, but isn't written on every upload or collect.
+func (t *Telemetry) counter(name string, delta metrics.Counter) (metrics.Metric, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.registered[name]
+	if ok && delta == 0 {
+		return metrics.Metric{}, false
+	}
+	t.registered[name] = struct{}{}
+	return metrics.NewMetricCounter(name, delta), true
+}
+
+func (t *Telemetry) record(mtrcs ...metrics.Metric) {
+	err := t.buffer.BatchUpdate(context.Background(), mtrcs)
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = t.totals.BatchUpdate(context.Background(), mtrcs)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Upload - records upload of batch of given size. Sent is total number of bytes sent by client,
+// only its growth since previous upload is counted.
+func (t *Telemetry) Upload(duration time.Duration, batch int, sent uint64, err error) {
+	t.mu.Lock()
+	var sentDelta uint64
+	if sent > t.sent {
+		sentDelta = sent - t.sent
+		t.sent = sent
+	}
+	if err == nil {
+		t.lastSuccess = t.now()
+		t.failures = 0
+	} else {
+		t.lastFailure = t.now()
+		t.lastError = err.Error()
+		t.failures++
+	}
+	t.mu.Unlock()
+
+	var uploads, failures metrics.Counter = 1, 0
+	if err != nil {
+		uploads, failures = 0, 1
+	}
+
+	mtrcs := []metrics.Metric{
+		metrics.NewMetricGauge(UploadDuration, metrics.Gauge(duration.Seconds())),
+		metrics.NewMetricGauge(BufferedMetrics, metrics.Gauge(batch)),
+	}
+	for name, delta := range map[string]metrics.Counter{SentBytes: metrics.Counter(sentDelta), Uploads: uploads, UploadFailures: failures} {
+		if metric, ok := t.counter(name, delta); ok {
+			mtrcs = append(mtrcs, metric)
+		}
+	}
+	t.record(mtrcs...)
+}
+
+// Collect - records duration of collector's poll and whether it failed.
+func (t *Telemetry) Collect(collector string, duration time.Duration, err error) {
+	failures := metrics.Counter(0)
+	if err != nil {
+		failures = 1
+	}
+
+	mtrcs := []metrics.Metric{metrics.NewMetricGauge(CollectDuration(collector), metrics.Gauge(duration.Seconds()))}
+	if metric, ok := t.counter(CollectFailures(collector), failures); ok {
+		mtrcs = append(mtrcs, metric)
+	}
+	t.record(mtrcs...)
+}
+
+// Dropped - counts task dropped because worker pool was busy.
+func (t *Telemetry) Dropped(task string) {
+	t.record(metrics.NewMetricCounter(DroppedTasks(task), 1))
+}
+
+// Queued - records number of tasks waiting for free worker.
+func (t *Telemetry) Queued(tasks int) {
+	t.record(metrics.NewMetricGauge(QueuedTasks, metrics.Gauge(tasks)))
+}
+
+// Health - returns health of agent, it is failing after several consecutive failed uploads.
+func (t *Telemetry) Health() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := Health{Status: StatusOK, LastError: t.lastError, ConsecutiveFailures: t.failures}
+	if t.failures >= unhealthyFailures {
+		h.Status = StatusFailing
+	}
+	if !t.lastSuccess.IsZero() {
+		lastSuccess := t.lastSuccess
+		h.LastSuccess = &lastSuccess
+	}
+	if !t.lastFailure.IsZero() {
+		lastFailure := t.lastFailure
+		h.LastFailure = &lastFailure
+	}
+	return h
+}
+
+// HealthHandler - handler of "/healthz", responds 503 if agent is failing.
+func (t *Telemetry) HealthHandler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		h := t.Health()
+
+		rw.Header().Set("Content-Type", "application/json")
+		if h.Status != StatusOK {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		err := json.NewEncoder(rw).Encode(h)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// MetricsHandler - handler of "/metrics", writes totals of telemetry in prometheus text format.
+func (t *Telemetry) MetricsHandler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		totals, err := t.totals.GetMetricsMap(r.Context())
+		if err != nil {
+			log.Println(err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		names := make([]string, 0, len(totals))
+		for name := range totals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var b strings.Builder
+		for _, name := range names {
+			metric := totals[name]
+			switch metric.GetKind() {
+			case "gauge":
+				fmt.Fprintf(&b, "# TYPE %s gauge\n%s %s\n", name, name,
+					strconv.FormatFloat(float64(metric.GetGaugeValue()), 'g', -1, 64))
+			case "counter":
+				fmt.Fprintf(&b, "# TYPE %s counter\n%s %d\n", name, name, metric.GetCounterValue())
+			}
+		}
+
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, err = rw.Write([]byte(b.String()))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
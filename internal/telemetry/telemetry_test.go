@@ -0,0 +1,116 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTelemetry_Upload(t *testing.T) {
+	buffer := repository.NewMemStorage()
+	tm := New(buffer)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tm.now = func() time.Time { return now }
+
+	tm.Upload(time.Second, 10, 100, nil)
+	tm.Upload(time.Second, 10, 150, errors.New("connection refused"))
+
+	tests := []struct {
+		name string
+		want metrics.Metric
+	}{
+		{name: "Uploads", want: metrics.NewMetricCounter(Uploads, 1)},
+		{name: "Failures", want: metrics.NewMetricCounter(UploadFailures, 1)},
+		{name: "Sent bytes are growth of client's total", want: metrics.NewMetricCounter(SentBytes, 150)},
+		{name: "Duration", want: metrics.NewMetricGauge(UploadDuration, 1)},
+		{name: "Buffered metrics", want: metrics.NewMetricGauge(BufferedMetrics, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := buffer.GetMetric(context.Background(), tt.want.GetName())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, metric)
+		})
+	}
+}
+
+func TestTelemetry_ZeroCountersOnce(t *testing.T) {
+	buffer := repository.NewMemStorage()
+	tm := New(buffer)
+
+	tm.Collect("runtime", time.Millisecond, nil)
+	tm.Upload(time.Millisecond, 1, 0, nil)
+	drained, err := buffer.Drain(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, drained, CollectFailures("runtime"), "zero counter must be registered")
+	assert.Contains(t, drained, UploadFailures, "zero counter must be registered")
+
+	tm.Collect("runtime", time.Millisecond, nil)
+	tm.Upload(time.Millisecond, 1, 0, nil)
+	drained, err = buffer.Drain(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, drained, CollectFailures("runtime"), "zero increment mustn't be written again")
+	assert.NotContains(t, drained, UploadFailures, "zero increment mustn't be written again")
+	assert.Contains(t, drained, Uploads)
+}
+
+func TestTelemetry_Health(t *testing.T) {
+	tm := New(repository.NewMemStorage())
+
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+		failures   int
+	}{
+		{name: "Success", statusCode: http.StatusOK},
+		{name: "Single failure is tolerated", err: errors.New("timeout"), statusCode: http.StatusOK, failures: 1},
+		{name: "Second failure", err: errors.New("timeout"), statusCode: http.StatusOK, failures: 2},
+		{name: "Consecutive failures", err: errors.New("timeout"), statusCode: http.StatusServiceUnavailable, failures: 3},
+		{name: "Success resets failures", statusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm.Upload(time.Millisecond, 1, 0, tt.err)
+
+			recorder := httptest.NewRecorder()
+			tm.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, tt.statusCode, recorder.Code)
+
+			h := tm.Health()
+			assert.Equal(t, tt.failures, h.ConsecutiveFailures)
+			assert.NotNil(t, h.LastSuccess)
+		})
+	}
+}
+
+func TestTelemetry_MetricsHandler(t *testing.T) {
+	tm := New(repository.NewMemStorage())
+	tm.Collect("runtime", 500*time.Millisecond, nil)
+	tm.Dropped("upload")
+	tm.Dropped("upload")
+
+	recorder := httptest.NewRecorder()
+	tm.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	assert.Equal(t, ""+
+		"# TYPE agent_collector_runtime_duration_seconds gauge\n"+
+		"agent_collector_runtime_duration_seconds 0.5\n"+
+		"# TYPE agent_collector_runtime_failures_total counter\n"+
+		"agent_collector_runtime_failures_total 0\n"+
+		"# TYPE agent_dropped_upload_tasks_total counter\n"+
+		"agent_dropped_upload_tasks_total 2\n", string(body))
+}
@@ -0,0 +1,59 @@
+// Package testutil - fixtures shared by tests of server and client packages.
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// WriteKeys - generates throwaway RSA key pair and returns paths to private and public PEM files.
+func WriteKeys(t testing.TB) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey),
+	}), 0600))
+
+	return privatePath, publicPath
+}
+
+// TokenStore - creates store with one token per scope, returns store and secrets of tokens by scope.
+func TokenStore(t testing.TB) (*auth.Store, map[auth.Scope]string) {
+	secrets := make(map[auth.Scope]string)
+	var tokens []auth.Token
+	for _, scope := range []auth.Scope{auth.ScopeIngest, auth.ScopeRead, auth.ScopeAdmin} {
+		secret, token, err := auth.Generate(string(scope), scope)
+		require.NoError(t, err)
+		secrets[scope] = secret
+		tokens = append(tokens, token)
+	}
+
+	store, err := auth.NewStore(tokens...)
+	require.NoError(t, err)
+	return store, secrets
+}
+
+// Keyring - creates keyring of given keys.
+func Keyring(t testing.TB, keys ...hash.Key) *hash.Keyring {
+	keyring, err := hash.NewKeyring(keys...)
+	require.NoError(t, err)
+	return keyring
+}
@@ -0,0 +1,132 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// Reloader - keeps certificate, key and CA bundle loaded from files.
+// Configs returned by Reloader pick up reloaded files for every new connection,
+// so certificates are rotated without restarting listeners.
+type Reloader struct {
+	certPath string
+	keyPath  string
+	caPath   string
+	state    atomic.Pointer[state]
+}
+
+type state struct {
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// New - loads certificate with key and CA bundle. Any of paths may be empty, but certificate requires key.
+func New(certPath, keyPath, caPath string) (*Reloader, error) {
+	if (certPath == "") != (keyPath == "") {
+		return nil, errors.New("tls certificate and key must be provided together")
+	}
+
+	r := &Reloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+	}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload - rereads files, keeps previous state on failure.
+func (r *Reloader) Reload() error {
+	st := &state{}
+
+	if r.certPath != "" {
+		cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+		if err != nil {
+			return err
+		}
+		st.cert = &cert
+	}
+
+	if r.caPath != "" {
+		data, err := os.ReadFile(r.caPath)
+		if err != nil {
+			return err
+		}
+
+		st.pool = x509.NewCertPool()
+		if !st.pool.AppendCertsFromPEM(data) {
+			return errors.New("no certificates in CA bundle " + r.caPath)
+		}
+	}
+
+	r.state.Store(st)
+	return nil
+}
+
+// ServerConfig - returns config for listeners. If CA bundle is provided, clients must present certificate signed by it.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+		GetConfigForClient: func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+			// State is loaded once, so certificate and CA bundle are from the same reload
+			s := r.state.Load()
+			cert, err := s.certificate()
+			if err != nil {
+				return nil, err
+			}
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+
+			if s.pool != nil {
+				config.ClientCAs = s.pool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig - returns config for connections to server. Server is verified against CA bundle
+// or system roots, client certificate is presented if provided. CA bundle isn't reloaded for client.
+func (r *Reloader) ClientConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    r.state.Load().pool,
+	}
+
+	if r.certPath != "" {
+		config.GetClientCertificate = func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		}
+	}
+
+	return config
+}
+
+func (r *Reloader) certificate() (*tls.Certificate, error) {
+	return r.state.Load().certificate()
+}
+
+func (s *state) certificate() (*tls.Certificate, error) {
+	if s.cert == nil {
+		return nil, errors.New("tls certificate is not provided")
+	}
+
+	return s.cert, nil
+}
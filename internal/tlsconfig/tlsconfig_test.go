@@ -0,0 +1,262 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/grpcserver"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+// newAuthority - generates throwaway CA and writes its certificate to ca.pem.
+func newAuthority(t *testing.T) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	a := &authority{cert: cert, key: key, dir: t.TempDir()}
+	require.NoError(t, os.WriteFile(a.caPath(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return a
+}
+
+func (a *authority) caPath() string {
+	return filepath.Join(a.dir, "ca.pem")
+}
+
+// issue - writes certificate signed by CA with given serial to name.pem and its key to name-key.pem.
+func (a *authority) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(a.dir, name+".pem")
+	keyPath := filepath.Join(a.dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func TestNew(t *testing.T) {
+	ca := newAuthority(t)
+	certPath, keyPath := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	tests := []struct {
+		name     string
+		certPath string
+		keyPath  string
+		caPath   string
+		wantErr  bool
+	}{
+		{
+			name:     "Certificate with CA",
+			certPath: certPath,
+			keyPath:  keyPath,
+			caPath:   ca.caPath(),
+		},
+		{
+			name:   "CA only",
+			caPath: ca.caPath(),
+		},
+		{
+			name:     "Certificate without key",
+			certPath: certPath,
+			wantErr:  true,
+		},
+		{
+			name:     "Missing file",
+			certPath: filepath.Join(ca.dir, "missing.pem"),
+			keyPath:  keyPath,
+			wantErr:  true,
+		},
+		{
+			name:    "CA bundle without certificates",
+			caPath:  keyPath,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.certPath, tt.keyPath, tt.caPath)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestMutualTLS_HTTP(t *testing.T) {
+	ca := newAuthority(t)
+	serverCert, serverKey := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+
+	serverReloader, err := New(serverCert, serverKey, ca.caPath())
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = serverReloader.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	withCert, err := New(clientCert, clientKey, ca.caPath())
+	require.NoError(t, err)
+	withoutCert, err := New("", "", ca.caPath())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		reloader *Reloader
+		wantErr  bool
+	}{
+		{
+			name:     "Client certificate",
+			reloader: withCert,
+		},
+		{
+			name:     "No client certificate",
+			reloader: withoutCert,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tt.reloader.ClientConfig()}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(server.URL)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ca := newAuthority(t)
+	certPath, keyPath := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	reloader, err := New(certPath, keyPath, "")
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	server.TLS = reloader.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	clientReloader, err := New("", "", ca.caPath())
+	require.NoError(t, err)
+
+	servedSerial := func() int64 {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), clientReloader.ClientConfig())
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	assert.Equal(t, int64(2), servedSerial())
+
+	// Rotated files are picked up by new connections.
+	ca.issue(t, "server", 5, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, int64(5), servedSerial())
+
+	// Broken files keep previous certificate.
+	require.NoError(t, os.WriteFile(certPath, []byte("broken"), 0600))
+	require.Error(t, reloader.Reload())
+	assert.Equal(t, int64(5), servedSerial())
+}
+
+func TestMutualTLS_GRPC(t *testing.T) {
+	ca := newAuthority(t)
+	serverCert, serverKey := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+
+	serverReloader, err := New(serverCert, serverKey, ca.caPath())
+	require.NoError(t, err)
+	clientReloader, err := New(clientCert, clientKey, ca.caPath())
+	require.NoError(t, err)
+
+	storage := repository.NewMemStorage()
+	server := grpcserver.New(storage, nil, grpc.Creds(credentials.NewTLS(serverReloader.ServerConfig())))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientReloader.ClientConfig())))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = proto.NewMetricsCollectionClient(conn).UpdateMetrics(ctx, &proto.BatchUpdateMetricsRequest{
+		Metrics: []*proto.Metrics{{ID: "PollCount", MType: proto.Metrics_COUNTER, Delta: 1}},
+	})
+	require.NoError(t, err)
+
+	metric, err := storage.GetMetric(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, metric.GetCounterValue())
+}
@@ -0,0 +1,373 @@
+package utils
+
+import (
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/openapi"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ratelimit"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/testutil"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas struct {
+			Error struct {
+				Properties struct {
+					Code struct {
+						Enum []string `json:"enum"`
+					} `json:"code"`
+				} `json:"properties"`
+			} `json:"Error"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDocument {
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(openapi.Spec(), &doc))
+	return doc
+}
+
+func TestNewRouter_MatchesOpenAPI(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	var documented []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	var routed []string
+	router := NewRouter(repository.NewMemStorage(), nil, nil, nil)
+	err := chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/debug") {
+			return nil
+		}
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	sort.Strings(documented)
+	sort.Strings(routed)
+	assert.Equal(t, documented, routed)
+}
+
+func TestNewRouter_ErrorEnvelope(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := NewRouter(repository.NewMemStorage(), nil, nil, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		statusCode int
+		code       string
+	}{
+		{
+			name:       "Malformed json",
+			method:     http.MethodPost,
+			target:     "/update/",
+			body:       "{",
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeInvalidRequest,
+		},
+		{
+			name:       "Unsupported type",
+			method:     http.MethodPost,
+			target:     "/update/foo/test/1",
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeUnsupportedType,
+		},
+		{
+			name:       "Unsupported type in json",
+			method:     http.MethodPost,
+			target:     "/update/",
+			body:       `{"id":"test","type":"foo"}`,
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeUnsupportedType,
+		},
+		{
+			name:       "Unsupported type in batch",
+			method:     http.MethodPost,
+			target:     "/updates/",
+			body:       `[{"id":"test","type":"foo"}]`,
+			statusCode: http.StatusBadRequest,
+			code:       apierror.CodeUnsupportedType,
+		},
+		{
+			name:       "Unknown metric",
+			method:     http.MethodGet,
+			target:     "/value/gauge/unknown",
+			statusCode: http.StatusNotFound,
+			code:       apierror.CodeNotFound,
+		},
+		{
+			name:       "Debug without tokens",
+			method:     http.MethodGet,
+			target:     "/debug/pprof/",
+			statusCode: http.StatusForbidden,
+			code:       apierror.CodeForbidden,
+		},
+		{
+			name:       "Audit without tokens",
+			method:     http.MethodGet,
+			target:     "/audit",
+			statusCode: http.StatusForbidden,
+			code:       apierror.CodeForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.statusCode, result.StatusCode)
+			assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+
+			var body apierror.Response
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&body))
+			assert.Equal(t, tt.code, body.Code)
+			assert.NotEmpty(t, body.Message)
+			assert.NotEmpty(t, body.RequestID)
+			assert.Contains(t, doc.Components.Schemas.Error.Properties.Code.Enum, body.Code)
+		})
+	}
+}
+
+func TestNewRouter_Tokens(t *testing.T) {
+	store, secrets := testutil.TokenStore(t)
+
+	router := NewRouter(repository.NewMemStorage(), nil, nil, nil, WithTokens(store))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		scope      auth.Scope
+		statusCode int
+	}{
+		{
+			name:       "OpenAPI document is public",
+			method:     http.MethodGet,
+			target:     "/openapi.json",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Update without token",
+			method:     http.MethodPost,
+			target:     "/update/gauge/test/1",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Update with ingest token",
+			method:     http.MethodPost,
+			target:     "/update/gauge/test/1",
+			scope:      auth.ScopeIngest,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Update with read token",
+			method:     http.MethodPost,
+			target:     "/update/gauge/test/1",
+			scope:      auth.ScopeRead,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Read with ingest token",
+			method:     http.MethodGet,
+			target:     "/",
+			scope:      auth.ScopeIngest,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Read with read token",
+			method:     http.MethodGet,
+			target:     "/",
+			scope:      auth.ScopeRead,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Debug with read token",
+			method:     http.MethodGet,
+			target:     "/debug/pprof/",
+			scope:      auth.ScopeRead,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Debug with admin token",
+			method:     http.MethodGet,
+			target:     "/debug/pprof/",
+			scope:      auth.ScopeAdmin,
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.scope != "" {
+				request.Header.Set("Authorization", "Bearer "+secrets[tt.scope])
+			}
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			assert.Equal(t, tt.statusCode, recorder.Code)
+		})
+	}
+
+	// Decompressing body of rejected client would waste server's resources
+	request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("not gzip"))
+	request.Header.Set("Content-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "token must be checked before body is decoded")
+}
+
+func TestNewRouter_SubnetCheck(t *testing.T) {
+	filter, err := ipfilter.New("10.0.0.0/8,2001:db8::/32", "10.0.0.13")
+	require.NoError(t, err)
+	proxies, err := ipfilter.NewResolver("192.168.0.1")
+	require.NoError(t, err)
+
+	router := NewRouter(repository.NewMemStorage(), nil, nil, filter, WithClientIPResolver(proxies))
+
+	tests := []struct {
+		name       string
+		remote     string
+		headers    map[string]string
+		statusCode int
+	}{
+		{
+			name:       "Allowed client",
+			remote:     "10.1.2.3:40000",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Allowed IPv6 client",
+			remote:     "[2001:db8::7]:40000",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Denied client",
+			remote:     "10.0.0.13:40000",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Spoofed header",
+			remote:     "203.0.113.1:40000",
+			headers:    map[string]string{"X-Real-IP": "10.1.2.3"},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "Forwarded by trusted proxy",
+			remote:     "192.168.0.1:40000",
+			headers:    map[string]string{"X-Forwarded-For": "10.1.2.3"},
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/update/gauge/test/1", nil)
+			request.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			assert.Equal(t, tt.statusCode, recorder.Code)
+		})
+	}
+}
+
+func TestNewRouter_Limits(t *testing.T) {
+	storage := repository.NewLimitedStorage(repository.NewMemStorage(), repository.Limits{MaxBatch: 2, MaxSeries: 3})
+	policy := ratelimit.Policy{PerClient: ratelimit.New(1, 4)}
+	router := NewRouter(storage, nil, nil, nil, WithMaxBodySize(128), WithRateLimit(policy))
+
+	tests := []struct {
+		name       string
+		body       string
+		chunked    bool
+		statusCode int
+		code       string
+	}{
+		{
+			name:       "Body over limit",
+			body:       "[" + strings.Repeat(" ", 128) + "]",
+			statusCode: http.StatusRequestEntityTooLarge,
+			code:       apierror.CodePayloadTooLarge,
+		},
+		{
+			name:       "Chunked body over limit",
+			body:       "[" + strings.Repeat(" ", 128) + "]",
+			chunked:    true,
+			statusCode: http.StatusRequestEntityTooLarge,
+			code:       apierror.CodePayloadTooLarge,
+		},
+		{
+			name:       "Batch over limit",
+			body:       `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1}]`,
+			statusCode: http.StatusRequestEntityTooLarge,
+			code:       apierror.CodePayloadTooLarge,
+		},
+		{
+			name:       "Series over limit",
+			body:       `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1}]`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "Series over limit",
+			body:       `[{"id":"c","type":"gauge","value":1},{"id":"d","type":"gauge","value":1}]`,
+			statusCode: http.StatusUnprocessableEntity,
+			code:       apierror.CodeSeriesLimit,
+		},
+		{
+			name:       "Rate over limit",
+			body:       `[{"id":"a","type":"gauge","value":2}]`,
+			statusCode: http.StatusTooManyRequests,
+			code:       apierror.CodeRateLimited,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
+			if tt.chunked {
+				request.ContentLength = -1
+			}
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+			require.Equal(t, tt.statusCode, recorder.Code)
+			if tt.code == "" {
+				return
+			}
+
+			var body apierror.Response
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&body))
+			assert.Equal(t, tt.code, body.Code)
+		})
+	}
+}
@@ -1,58 +1,183 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/audit"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/crypt"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/handlers"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ipfilter"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
 	"github.com/VladimirMovsesyan/praktikum-devops/internal/middleware"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/openapi"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/ratelimit"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
 )
 
 type metricRepository interface {
-	GetMetricsMap() map[string]metrics.Metric
-	GetMetric(name string) (metrics.Metric, error)
-	Update(metrics.Metric)
-	BatchUpdate(metrics []metrics.Metric)
+	GetMetricsMap(ctx context.Context) (map[string]metrics.Metric, error)
+	GetMetric(ctx context.Context, name string) (metrics.Metric, error)
+	Update(ctx context.Context, metric metrics.Metric) error
+	BatchUpdate(ctx context.Context, metrics []metrics.Metric) error
 }
 
-func NewRouter(storage metricRepository, key string, db *sql.DB, subnet string) chi.Router {
+type routerOptions struct {
+	decrypt func(http.Handler) http.Handler
+	verify  func(http.Handler) http.Handler
+	tokens  *auth.Store
+	proxies *ipfilter.Resolver
+	maxBody int64
+	limit   ratelimit.Policy
+	audit   *audit.Auditor
+}
+
+// RouterOption - optional feature of router.
+type RouterOption func(o *routerOptions)
+
+// WithDecryption - makes router decrypt request bodies, encryption becomes required for requests with body.
+func WithDecryption(c crypt.Crypter) RouterOption {
+	return func(o *routerOptions) {
+		o.decrypt = c.GetDecryptMiddleware()
+	}
+}
+
+// WithSignatureVerifier - makes router verify request-level signatures of updates.
+func WithSignatureVerifier(v *signature.Verifier) RouterOption {
+	return func(o *routerOptions) {
+		o.verify = v.Middleware
+	}
+}
+
+// WithTokens - makes router require bearer tokens: read scope for reading metrics,
+// ingest scope for updates and admin scope for debug endpoints. Without tokens debug endpoints are denied.
+func WithTokens(s *auth.Store) RouterOption {
+	return func(o *routerOptions) {
+		o.tokens = s
+	}
+}
+
+// WithClientIPResolver - makes router take client ip from forwarding headers set by trusted proxies.
+func WithClientIPResolver(res *ipfilter.Resolver) RouterOption {
+	return func(o *routerOptions) {
+		o.proxies = res
+	}
+}
+
+// WithMaxBodySize - makes router reject request bodies larger than n bytes, before and after decompression.
+func WithMaxBodySize(n int64) RouterOption {
+	return func(o *routerOptions) {
+		o.maxBody = n
+	}
+}
+
+// WithRateLimit - makes router limit rate of update requests.
+func WithRateLimit(p ratelimit.Policy) RouterOption {
+	return func(o *routerOptions) {
+		o.limit = p
+	}
+}
+
+// WithAudit - makes router record admin requests and serve audit log. Updates are recorded
+// by audit.Storage, router only passes request details to it.
+func WithAudit(a *audit.Auditor) RouterOption {
+	return func(o *routerOptions) {
+		o.audit = a
+	}
+}
+
+func NewRouter(storage metricRepository, keys *hash.Keyring, db *sql.DB, filter *ipfilter.Filter, opts ...RouterOption) chi.Router {
+	options := &routerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	router := chi.NewRouter()
 	router.Use(
-		chiMiddleware.RequestID,
-		chiMiddleware.RealIP,
+		middleware.RequestID,
+		options.proxies.Middleware,
 		chiMiddleware.Logger,
 		chiMiddleware.Recoverer,
 		middleware.Compress,
-		middleware.Decompress,
+		middleware.MaxBytes(options.maxBody),
 	)
+	if options.audit.Enabled() {
+		router.Use(audit.Middleware)
+	}
 
-	router.Get("/", handlers.PrintStorageHandler(storage))
+	// Bodies are compressed before encryption, so they are decrypted before decompression. Bodies are decoded
+	// only after client is checked, so rejected clients can't make server decrypt or decompress.
+	decodeBody := func(r chi.Router) {
+		if options.decrypt != nil {
+			r.Use(options.decrypt)
+		}
+		r.Use(middleware.Decompress, middleware.MaxBytes(options.maxBody))
+	}
+
+	router.Group(func(r chi.Router) {
+		r.Use(auth.Require(options.tokens, auth.ScopeRead))
+		decodeBody(r)
+		r.Get("/", handlers.PrintStorageHandler(storage))
 
-	router.Route("/value", func(r chi.Router) {
-		r.Post("/", handlers.JSONPrintHandler(storage, key))
-		r.Get("/{kind}/{name}", handlers.PrintValueHandler(storage, key))
+		r.Route("/value", func(rv chi.Router) {
+			rv.Post("/", handlers.JSONPrintHandler(storage, keys))
+			rv.Get("/{kind}/{name}", handlers.PrintValueHandler(storage, keys))
+		})
 	})
 
 	router.Group(func(r chi.Router) {
-		r.Use(middleware.SubnetCheck(subnet))
+		r.Use(middleware.SubnetCheck(filter))
+		r.Use(auth.Require(options.tokens, auth.ScopeIngest))
+		r.Use(options.limit.Middleware)
+		decodeBody(r)
+		if options.verify != nil {
+			r.Use(options.verify)
+		}
 		r.Route("/update", func(ru chi.Router) {
-			ru.Post("/", handlers.JSONUpdateHandler(storage, key))
-			ru.Post("/{kind}/{name}/{value}", handlers.UpdateStorageHandler(storage, key))
+			ru.Post("/", handlers.JSONUpdateHandler(storage, keys))
+			ru.Post("/{kind}/{name}/{value}", handlers.UpdateStorageHandler(storage, keys))
 		})
-		r.Post("/updates/", handlers.MetricsUpdateHandler(storage))
+		r.Post("/updates/", handlers.MetricsUpdateHandler(storage, keys))
 	})
 
 	router.Get("/ping", handlers.PingDatabaseHandler(db))
 
-	router.Mount("/debug", chiMiddleware.Profiler())
+	router.Get("/openapi.json", openapi.Handler())
+
+	router.Group(func(r chi.Router) {
+		r.Use(auth.RequireAdmin(options.tokens), options.audit.Admin)
+		r.Get("/audit", audit.Handler(options.audit))
+		r.Mount("/debug", chiMiddleware.Profiler())
+	})
 
 	return router
 }
 
+// KeyringKeys - collects signing keys from single key with optional ID and keyring file.
+func KeyringKeys(key, keyID, keyringPath string) ([]hash.Key, error) {
+	var keys []hash.Key
+	if key != "" {
+		keys = append(keys, hash.Key{ID: keyID, Secret: key})
+	}
+
+	if keyringPath != "" {
+		loaded, err := hash.LoadKeys(keyringPath)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, loaded...)
+	}
+
+	return keys, nil
+}
+
 func UpdateDurVar(envName string, fl *time.Duration, configValue time.Duration) time.Duration {
 	value, ok := os.LookupEnv(envName)
 	if !ok {
@@ -0,0 +1,60 @@
+// Package wire - formats of server's api shared by server and clients. It depends only on standard library.
+// pkg/client also shares hash, signature, crypt and auth packages with server, and through them and its gRPC
+// transport depends on grpc and genproto, but not on server's storage and routing.
+package wire
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxNameLength - max length of metric name in bytes, equals to metric_name column size.
+const MaxNameLength = 255
+
+// ValidName - reports whether server accepts metric name: non-empty utf-8 without NUL of at most MaxNameLength bytes.
+func ValidName(name string) bool {
+	return name != "" && len(name) <= MaxNameLength && utf8.ValidString(name) && !strings.ContainsRune(name, 0)
+}
+
+// AgentIDHeader - header and gRPC metadata key with ID that agent declares about itself.
+const AgentIDHeader = "X-Agent-ID"
+
+// ErrorDomain - domain of gRPC error details whose reason is one of codes below.
+const ErrorDomain = "praktikum-devops"
+
+// Codes of structured error responses.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidMetric      = "invalid_metric"
+	CodeUnsupportedType    = "unsupported_type"
+	CodeHashMismatch       = "hash_mismatch"
+	CodeInvalidSignature   = "invalid_signature"
+	CodeNotFound           = "not_found"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeEncryptionRequired = "encryption_required"
+	CodeDecryptionFailed   = "decryption_failed"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeSeriesLimit        = "series_limit"
+	CodeRateLimited        = "rate_limited"
+	CodeStorageUnavailable = "storage_unavailable"
+	CodeInternal           = "internal_error"
+)
+
+// ErrorResponse - error envelope, lets clients tell one failure from another and find it in server logs.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Metric - json representation of metric.
+type Metric struct {
+	ID            string   `json:"id"`                       // имя метрики
+	MType         string   `json:"type"`                     // параметр, принимающий значение gauge или counter
+	Delta         *int64   `json:"delta,omitempty"`          // значение метрики в случае передачи counter
+	Value         *float64 `json:"value,omitempty"`          // значение метрики в случае передачи gauge
+	Hash          string   `json:"hash,omitempty"`           // значение хеш-функции
+	KeyID         string   `json:"key_id,omitempty"`         // идентификатор ключа, которым подписан хеш
+	HashAlgorithm string   `json:"hash_algorithm,omitempty"` // алгоритм хеш-функции, без него используется устаревшая схема
+}
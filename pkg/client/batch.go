@@ -0,0 +1,147 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 1000
+	defaultMaxBatchSize  = 10000
+	defaultTargetLatency = time.Second
+)
+
+// PartialError - batch was pushed in chunks and only some of them were accepted. Failed are metrics
+// of rejected and unsent chunks, pushing them again doesn't duplicate accepted counters.
+type PartialError struct {
+	Failed []Metric
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d metrics weren't pushed: %s", len(e.Failed), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Failed - returns metrics of batch which weren't accepted because of err returned by push of batch.
+func Failed(batch []Metric, err error) []Metric {
+	if err == nil {
+		return nil
+	}
+
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		return partial.Failed
+	}
+	return batch
+}
+
+// batcher - adapts size of chunks to server: size grows while full chunks are accepted faster than target latency,
+// and is halved when chunk is slow, too large for server or server is overloaded.
+type batcher struct {
+	mu      sync.Mutex
+	size    int
+	maxSize int
+	target  time.Duration
+}
+
+func newBatcher(size, maxSize int, target time.Duration) *batcher {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	if size < 1 || size > maxSize {
+		size = maxSize
+	}
+	return &batcher{size: size, maxSize: maxSize, target: target}
+}
+
+// current - returns size of the next chunk.
+func (b *batcher) current() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.size
+}
+
+// accepted - adapts size to latency of accepted chunk, only full chunks show that size may grow.
+func (b *batcher) accepted(chunk int, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case latency > b.target:
+		b.shrink()
+	case chunk >= b.size && latency < b.target/2:
+		b.size += b.size/4 + 1
+		if b.size > b.maxSize {
+			b.size = b.maxSize
+		}
+	}
+}
+
+// rejected - shrinks size if server rejected chunk as too large or was overloaded.
+// Returns whether chunk should be split and pushed again right away.
+func (b *batcher) rejected(chunk int, err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case tooLarge(err):
+		if chunk <= 1 {
+			return false
+		}
+		if b.size >= chunk {
+			b.size = chunk
+		}
+		b.shrink()
+		return true
+	case overloaded(err):
+		b.shrink()
+	}
+	return false
+}
+
+func (b *batcher) shrink() {
+	b.size /= 2
+	if b.size < 1 {
+		b.size = 1
+	}
+}
+
+// tooLarge - reports whether server rejected batch because of its size.
+func tooLarge(err error) bool {
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		return false
+	}
+
+	return rejected.Code == wire.CodePayloadTooLarge || strings.HasPrefix(rejected.Status, "413")
+}
+
+// overloaded - reports whether server asked to slow down.
+func overloaded(err error) bool {
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		return false
+	}
+
+	return rejected.RetryAfter > 0 ||
+		rejected.Code == wire.CodeRateLimited ||
+		strings.HasPrefix(rejected.Status, "429") ||
+		strings.HasPrefix(rejected.Status, "503")
+}
+
+func fromInternal(metric metrics.Metric) Metric {
+	if metric.GetKind() == KindCounter {
+		return NewCounter(metric.GetName(), int64(metric.GetCounterValue()))
+	}
+	return NewGauge(metric.GetName(), float64(metric.GetGaugeValue()))
+}
@@ -0,0 +1,357 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/apierror"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/grpcserver"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	tooLargeErr := &RejectedError{Status: "413 Request Entity Too Large", Code: wire.CodePayloadTooLarge}
+	rateLimitedErr := &RejectedError{Status: "429 Too Many Requests", Code: wire.CodeRateLimited, RetryAfter: time.Second}
+
+	tests := []struct {
+		name      string
+		size      int
+		apply     func(b *batcher) bool
+		wantSize  int
+		wantRetry bool
+	}{
+		{
+			name:     "Fast full chunk grows size",
+			size:     100,
+			apply:    func(b *batcher) bool { b.accepted(100, time.Millisecond); return false },
+			wantSize: 126,
+		},
+		{
+			name:     "Fast partial chunk keeps size",
+			size:     100,
+			apply:    func(b *batcher) bool { b.accepted(10, time.Millisecond); return false },
+			wantSize: 100,
+		},
+		{
+			name:     "Growth is limited by max size",
+			size:     990,
+			apply:    func(b *batcher) bool { b.accepted(990, time.Millisecond); return false },
+			wantSize: 1000,
+		},
+		{
+			name:     "Slow chunk halves size",
+			size:     100,
+			apply:    func(b *batcher) bool { b.accepted(100, 2*time.Second); return false },
+			wantSize: 50,
+		},
+		{
+			name:      "Too large chunk is split",
+			size:      100,
+			apply:     func(b *batcher) bool { return b.rejected(40, tooLargeErr) },
+			wantSize:  20,
+			wantRetry: true,
+		},
+		{
+			name:     "Single metric isn't split",
+			size:     1,
+			apply:    func(b *batcher) bool { return b.rejected(1, tooLargeErr) },
+			wantSize: 1,
+		},
+		{
+			name:     "Rate limit halves size without retry",
+			size:     100,
+			apply:    func(b *batcher) bool { return b.rejected(100, rateLimitedErr) },
+			wantSize: 50,
+		},
+		{
+			name:     "Other errors keep size",
+			size:     100,
+			apply:    func(b *batcher) bool { return b.rejected(100, &RejectedError{Status: "500 Internal Server Error"}) },
+			wantSize: 100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBatcher(tt.size, 1000, time.Second)
+			assert.Equal(t, tt.wantRetry, tt.apply(b))
+			assert.Equal(t, tt.wantSize, b.current())
+		})
+	}
+}
+
+// batchServer - accepts batches of at most maxBatch metrics and fails requests listed in failures.
+type batchServer struct {
+	mu       sync.Mutex
+	maxBatch int
+	failures map[int]bool
+	requests int
+	gzipped  int
+	received []string
+}
+
+func (s *batchServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = reader
+		s.gzipped++
+	}
+
+	var batch []wire.Metric
+	err := json.NewDecoder(body).Decode(&batch)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if s.maxBatch > 0 && len(batch) > s.maxBatch {
+		apierror.Write(rw, r, http.StatusRequestEntityTooLarge, wire.CodePayloadTooLarge, "too many metrics in batch")
+		return
+	}
+
+	s.requests++
+	if s.failures[s.requests] {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, m := range batch {
+		s.received = append(s.received, m.ID)
+	}
+}
+
+func counters(n int) []Metric {
+	batch := make([]Metric, 0, n)
+	for i := 0; i < n; i++ {
+		batch = append(batch, NewCounter(fmt.Sprintf("Counter%d", i), 1))
+	}
+	return batch
+}
+
+func TestClient_Chunks(t *testing.T) {
+	tests := []struct {
+		name         string
+		server       *batchServer
+		opts         []Option
+		batch        int
+		wantReceived int
+		wantFailed   int
+		wantGzipped  bool
+	}{
+		{
+			name:         "Small batch is sent plain",
+			server:       &batchServer{},
+			batch:        5,
+			wantReceived: 5,
+		},
+		{
+			name:         "Large batch is compressed",
+			server:       &batchServer{},
+			batch:        500,
+			wantReceived: 500,
+			wantGzipped:  true,
+		},
+		{
+			name:         "Compression is disabled",
+			server:       &batchServer{},
+			opts:         []Option{WithGzipThreshold(0)},
+			batch:        500,
+			wantReceived: 500,
+		},
+		{
+			name:         "Batch is split to chunks",
+			server:       &batchServer{},
+			opts:         []Option{WithBatchSize(100, 100)},
+			batch:        250,
+			wantReceived: 250,
+			wantGzipped:  true,
+		},
+		{
+			name:         "Chunk too large for server is split",
+			server:       &batchServer{maxBatch: 30},
+			opts:         []Option{WithBatchSize(100, 100)},
+			batch:        250,
+			wantReceived: 250,
+			wantGzipped:  true,
+		},
+		{
+			name:         "Failed chunk stops push",
+			server:       &batchServer{failures: map[int]bool{2: true}},
+			opts:         []Option{WithBatchSize(100, 100)},
+			batch:        250,
+			wantReceived: 100,
+			wantFailed:   150,
+			wantGzipped:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.server)
+			defer server.Close()
+
+			c, err := New(server.Listener.Addr().String(), tt.opts...)
+			require.NoError(t, err)
+			defer c.Close()
+
+			batch := counters(tt.batch)
+			err = c.Push(context.Background(), batch)
+			if tt.wantFailed == 0 {
+				require.NoError(t, err)
+			} else {
+				var partial *PartialError
+				require.ErrorAs(t, err, &partial)
+				assert.Equal(t, batch[tt.wantReceived:], Failed(batch, err), "only unaccepted metrics must be pushed again")
+			}
+
+			assert.Len(t, tt.server.received, tt.wantReceived)
+			assert.Equal(t, tt.wantGzipped, tt.server.gzipped > 0)
+			if tt.server.maxBatch > 0 {
+				assert.Less(t, c.batcher.current(), 2*tt.server.maxBatch, "chunk size must keep close to server's limit")
+			}
+		})
+	}
+}
+
+func TestClient_GRPCChunkTooLarge(t *testing.T) {
+	storage := repository.NewMemStorage()
+	server := grpcserver.New(repository.NewLimitedStorage(storage, repository.Limits{MaxBatch: 30}), nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	c, err := New(listener.Addr().String(), WithGRPC(), WithBatchSize(100, 100))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Push(context.Background(), counters(250)))
+	got, err := storage.GetMetricsMap(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, got, 250)
+	assert.Less(t, c.batcher.current(), 60, "chunk size must keep close to server's limit")
+
+	chunk := make([]metrics.Metric, 0, 31)
+	for _, m := range counters(31) {
+		metric, err := m.internal()
+		require.NoError(t, err)
+		chunk = append(chunk, metric)
+	}
+	_, err = c.transport.push(context.Background(), chunk)
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, wire.CodePayloadTooLarge, rejected.Code, "code must be taken from status details")
+}
+
+func TestClient_DropsInvalid(t *testing.T) {
+	server := &batchServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	c, err := New(httpServer.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	batch := []Metric{
+		NewCounter("A", 1),
+		NewCounter(strings.Repeat("a", wire.MaxNameLength+1), 1),
+		NewGauge("NaN", math.NaN()),
+		NewGauge("B", 1),
+	}
+	require.NoError(t, c.Push(context.Background(), batch))
+	assert.Equal(t, []string{"A", "B"}, server.received, "metrics rejected by server mustn't fail the whole batch")
+}
+
+func TestClient_FlushPartial(t *testing.T) {
+	server := &batchServer{failures: map[int]bool{2: true}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	c, err := New(httpServer.Listener.Addr().String(), WithBatchSize(1, 1))
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Counter("A", 1)
+	c.Counter("B", 1)
+	c.Counter("C", 1)
+	require.Error(t, c.Flush(context.Background()))
+	require.Len(t, server.received, 1)
+
+	require.NoError(t, c.Flush(context.Background()))
+	assert.ElementsMatch(t, []string{"A", "B", "C"}, server.received, "accepted counters mustn't be pushed again")
+}
+
+// BenchmarkClient_Push - pushes 10k metrics to server which decodes them, with and without compression and chunking.
+// Bytes sent by request are reported as sent-B/op.
+func BenchmarkClient_Push(b *testing.B) {
+	batch := make([]Metric, 0, 10000)
+	for i := 0; i < 5000; i++ {
+		batch = append(batch,
+			NewGauge(fmt.Sprintf("benchGauge%d", i), float64(i)*1.5),
+			NewCounter(fmt.Sprintf("benchCounter%d", i), int64(i)),
+		)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = reader
+		}
+
+		var decoded []wire.Metric
+		if json.NewDecoder(body).Decode(&decoded) != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	benchmarks := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain", opts: []Option{WithGzipThreshold(0), WithBatchSize(10000, 10000)}},
+		{name: "gzip", opts: []Option{WithBatchSize(10000, 10000)}},
+		{name: "gzip-chunks-1000", opts: []Option{WithBatchSize(1000, 1000)}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c, err := New(server.Listener.Addr().String(), bm.opts...)
+			require.NoError(b, err)
+			defer c.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, c.Push(context.Background(), batch))
+			}
+			b.ReportMetric(float64(c.BytesSent())/float64(b.N), "sent-B/op")
+		})
+	}
+}
@@ -0,0 +1,486 @@
+// Package client - library for pushing application metrics to metrics server.
+//
+// Metrics are recorded in process and pushed in batches with the same semantics as the agent:
+// gauges keep the last recorded value, counters are sent as increments since previous push.
+// Batches are signed if keys are set, and encrypted over http if server's public key is set.
+// Large batches are pushed in chunks, whose size adapts to latency of server and to its 413 and 429 responses,
+// and large http bodies are compressed with gzip.
+//
+//	c, err := client.New("localhost:8080", client.WithKeys(client.Key{ID: "k1", Secret: "secret"}))
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	c.Counter("Requests", 1)
+//	c.Gauge("QueueLength", 12)
+//	err = c.Flush(ctx)
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of metrics.
+const (
+	KindGauge   = "gauge"
+	KindCounter = "counter"
+)
+
+// Hash algorithms, HashLegacy is understood by servers that don't support declared algorithms.
+const (
+	HashLegacy = string(hash.Legacy)
+	HashSHA256 = string(hash.SHA256)
+	HashSHA512 = string(hash.SHA512)
+)
+
+const (
+	defaultTimeout       = 3 * time.Second
+	defaultGzipThreshold = 1 << 10
+)
+
+// Key - HMAC signing key, keys are rotated by overlapping activation windows. Zero NotBefore or NotAfter
+// means the window is open on that side, the newest active key signs.
+type Key struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+}
+
+func hashKeys(keys []Key) []hash.Key {
+	result := make([]hash.Key, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, hash.Key{ID: k.ID, Secret: k.Secret, NotBefore: k.NotBefore, NotAfter: k.NotAfter})
+	}
+	return result
+}
+
+// Metric - pushed metric, Value is used by gauges and Delta by counters.
+type Metric struct {
+	Name  string
+	Kind  string
+	Value float64
+	Delta int64
+}
+
+// NewGauge - creates gauge.
+func NewGauge(name string, value float64) Metric {
+	return Metric{Name: name, Kind: KindGauge, Value: value}
+}
+
+// NewCounter - creates counter increment.
+func NewCounter(name string, delta int64) Metric {
+	return Metric{Name: name, Kind: KindCounter, Delta: delta}
+}
+
+func (m Metric) internal() (metrics.Metric, error) {
+	switch m.Kind {
+	case KindGauge:
+		return metrics.NewMetricGauge(m.Name, metrics.Gauge(m.Value)), nil
+	case KindCounter:
+		return metrics.NewMetricCounter(m.Name, metrics.Counter(m.Delta)), nil
+	default:
+		return metrics.Metric{}, fmt.Errorf("metric %q: unsupported kind %q", m.Name, m.Kind)
+	}
+}
+
+// RejectedError - server rejected batch. Status is http status or gRPC code, Code is error code of http api.
+// RetryAfter is the delay server asked to wait before the next push.
+type RejectedError struct {
+	Status     string
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *RejectedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("server rejected metrics: %s: %s, retry after %s", e.Status, e.Message, e.RetryAfter)
+	}
+	return fmt.Sprintf("server rejected metrics: %s: %s", e.Status, e.Message)
+}
+
+// RetryAfter - returns delay server asked to wait with err, or zero.
+func RetryAfter(err error) time.Duration {
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		return rejected.RetryAfter
+	}
+	return 0
+}
+
+// transport - way metrics are delivered to server.
+type transport interface {
+	// push - returns size of request sent to server, it is counted even if server rejected batch.
+	push(ctx context.Context, batch []metrics.Metric) (int, error)
+	close() error
+}
+
+type options struct {
+	keys      []Key
+	alg       string
+	publicKey string
+	tlsConfig *tls.Config
+	token     string
+	agentID   string
+	grpc      bool
+	timeout   time.Duration
+
+	gzipThreshold int
+	batchSize     int
+	maxBatchSize  int
+	targetLatency time.Duration
+}
+
+// Option - optional setting of client.
+type Option func(o *options)
+
+// WithKeys - makes client sign metrics and requests with the current key.
+func WithKeys(keys ...Key) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithHashAlgorithm - sets hash algorithm of signatures, HashLegacy by default. Declared algorithms
+// must be set only after every server is upgraded, servers that don't support them reject such signatures.
+func WithHashAlgorithm(alg string) Option {
+	return func(o *options) {
+		o.alg = alg
+	}
+}
+
+// WithPublicKey - makes client encrypt batches with server's public key from PEM file, it is supported only over http.
+func WithPublicKey(path string) Option {
+	return func(o *options) {
+		o.publicKey = path
+	}
+}
+
+// WithTLSConfig - makes client connect to server over TLS.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// WithToken - makes client authenticate with bearer token.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithAgentID - makes client declare its ID to server, it is recorded in audit log.
+func WithAgentID(id string) Option {
+	return func(o *options) {
+		o.agentID = id
+	}
+}
+
+// WithGRPC - makes client push over gRPC instead of http, address must be address of gRPC server.
+func WithGRPC() Option {
+	return func(o *options) {
+		o.grpc = true
+	}
+}
+
+// WithTimeout - sets timeout of push of single chunk, 3 seconds by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithGzipThreshold - sets size of http body in bytes from which it is compressed, 1KB by default.
+// Non-positive threshold disables compression.
+func WithGzipThreshold(threshold int) Option {
+	return func(o *options) {
+		o.gzipThreshold = threshold
+	}
+}
+
+// WithBatchSize - sets initial and maximal number of metrics in chunk, 1000 and 10000 by default.
+func WithBatchSize(initial, maxSize int) Option {
+	return func(o *options) {
+		o.batchSize, o.maxBatchSize = initial, maxSize
+	}
+}
+
+// WithTargetLatency - sets latency of server which chunk size is adapted to, 1 second by default.
+func WithTargetLatency(latency time.Duration) Option {
+	return func(o *options) {
+		o.targetLatency = latency
+	}
+}
+
+// Client - buffers recorded metrics and pushes them to server. It is safe for concurrent use.
+type Client struct {
+	sent      uint64 // first field to be 64-bit aligned for atomic operations
+	keys      *hash.Keyring
+	alg       hash.Algorithm
+	timeout   time.Duration
+	transport transport
+	batcher   *batcher
+
+	mu       sync.Mutex
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+// New - creates client of server at address given as host:port.
+func New(address string, opts ...Option) (*Client, error) {
+	o := options{
+		alg:           HashLegacy,
+		timeout:       defaultTimeout,
+		gzipThreshold: defaultGzipThreshold,
+		batchSize:     defaultBatchSize,
+		maxBatchSize:  defaultMaxBatchSize,
+		targetLatency: defaultTargetLatency,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	alg, err := hash.ParseAlgorithm(o.alg)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := hash.NewKeyring(hashKeys(o.keys)...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		keys:     keys,
+		alg:      alg,
+		timeout:  o.timeout,
+		batcher:  newBatcher(o.batchSize, o.maxBatchSize, o.targetLatency),
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+
+	if o.grpc {
+		if o.publicKey != "" {
+			return nil, errors.New("encryption is supported only over http, use TLS with gRPC")
+		}
+		c.transport, err = newGRPCTransport(address, keys, alg, o)
+	} else {
+		c.transport, err = newHTTPTransport(address, keys, alg, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SetKeys - replaces signing keys, e.g. on rotation.
+func (c *Client) SetKeys(keys ...Key) error {
+	return c.keys.Set(hashKeys(keys)...)
+}
+
+// Gauge - records value of gauge, the last value is pushed.
+func (c *Client) Gauge(name string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gauges[name] = value
+}
+
+// Counter - adds delta to counter.
+func (c *Client) Counter(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counters[name] += delta
+}
+
+// Flush - pushes recorded metrics. Metrics aren't lost if push failed: metrics which weren't accepted
+// are pushed with the next flush unless gauge was recorded again.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	gauges, counters := c.gauges, c.counters
+	c.gauges, c.counters = make(map[string]float64), make(map[string]int64)
+	c.mu.Unlock()
+
+	if len(gauges) == 0 && len(counters) == 0 {
+		return nil
+	}
+
+	batch := make([]metrics.Metric, 0, len(gauges)+len(counters))
+	for name, value := range gauges {
+		batch = append(batch, metrics.NewMetricGauge(name, metrics.Gauge(value)))
+	}
+	for name, delta := range counters {
+		batch = append(batch, metrics.NewMetricCounter(name, metrics.Counter(delta)))
+	}
+
+	err := c.push(ctx, batch)
+	if err == nil {
+		return nil
+	}
+
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		gauges, counters = make(map[string]float64), make(map[string]int64)
+		for _, m := range partial.Failed {
+			if m.Kind == KindCounter {
+				counters[m.Name] += m.Delta
+			} else {
+				gauges[m.Name] = m.Value
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, value := range gauges {
+		if _, ok := c.gauges[name]; !ok {
+			c.gauges[name] = value
+		}
+	}
+	for name, delta := range counters {
+		c.counters[name] += delta
+	}
+	return err
+}
+
+// Push - pushes batch immediately, bypassing recorded metrics. If batch was pushed partially, error is
+// *PartialError, use Failed to get metrics which should be pushed again.
+func (c *Client) Push(ctx context.Context, batch []Metric) error {
+	converted := make([]metrics.Metric, 0, len(batch))
+	for _, m := range batch {
+		metric, err := m.internal()
+		if err != nil {
+			return err
+		}
+		converted = append(converted, metric)
+	}
+
+	return c.push(ctx, converted)
+}
+
+// push - pushes batch in chunks of adaptive size, each one with its own timeout. Chunk rejected as too large
+// is split and pushed again, otherwise push stops at the first chunk which wasn't accepted.
+func (c *Client) push(ctx context.Context, batch []metrics.Metric) error {
+	batch = dropInvalid(batch)
+	for sent := 0; sent < len(batch); {
+		size := c.batcher.current()
+		if size > len(batch)-sent {
+			size = len(batch) - sent
+		}
+		chunk := batch[sent : sent+size]
+
+		start := time.Now()
+		err := c.pushChunk(ctx, chunk)
+		if err == nil {
+			c.batcher.accepted(len(chunk), time.Since(start))
+			sent += size
+			continue
+		}
+		if c.batcher.rejected(len(chunk), err) {
+			continue
+		}
+
+		if sent == 0 {
+			return err
+		}
+		failed := make([]Metric, 0, len(batch)-sent)
+		for _, metric := range batch[sent:] {
+			failed = append(failed, fromInternal(metric))
+		}
+		return &PartialError{Failed: failed, Err: err}
+	}
+
+	return nil
+}
+
+// dropInvalid - drops and logs metrics which server would reject, so they don't fail the whole batch.
+func dropInvalid(batch []metrics.Metric) []metrics.Metric {
+	for i, metric := range batch {
+		if valid(metric) {
+			continue
+		}
+
+		result := append(make([]metrics.Metric, 0, len(batch)-1), batch[:i]...)
+		for _, m := range batch[i:] {
+			if !valid(m) {
+				log.Printf("metric %q is dropped: server doesn't accept its name or value", m.GetName())
+				continue
+			}
+			result = append(result, m)
+		}
+		return result
+	}
+	return batch
+}
+
+func valid(metric metrics.Metric) bool {
+	if !wire.ValidName(metric.GetName()) {
+		return false
+	}
+	value := float64(metric.GetGaugeValue())
+	return metric.GetKind() != KindGauge || !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+func (c *Client) pushChunk(ctx context.Context, chunk []metrics.Metric) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	n, err := c.transport.push(ctx, chunk)
+	atomic.AddUint64(&c.sent, uint64(n))
+	return err
+}
+
+// BytesSent - returns total size of requests sent to server, encrypted batches are counted after encryption.
+func (c *Client) BytesSent() uint64 {
+	return atomic.LoadUint64(&c.sent)
+}
+
+// Run - flushes recorded metrics every interval till ctx is done, then flushes them the last time.
+// Failed flushes are logged, flushes are skipped while server asked to wait.
+func (c *Client) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var retryAt time.Time
+	for {
+		select {
+		case <-ticker.C:
+			if time.Now().Before(retryAt) {
+				continue
+			}
+
+			err := c.Flush(ctx)
+			if err != nil {
+				log.Println(err)
+				retryAt = time.Now().Add(RetryAfter(err))
+			}
+		case <-ctx.Done():
+			err := c.Flush(context.Background())
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// Close - releases connection to server, recorded metrics must be flushed before.
+func (c *Client) Close() error {
+	return c.transport.close()
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/crypt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/grpcserver"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/repository"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/testutil"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testKey = Key{ID: "k1", Secret: "secret"}
+
+func requireMetrics(t *testing.T, storage *repository.MemStorage, want map[string]metrics.Metric) {
+	got, err := storage.GetMetricsMap(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestClient_HTTP(t *testing.T) {
+	keyring := testutil.Keyring(t, hashKeys([]Key{testKey})...)
+	privatePath, publicPath := testutil.WriteKeys(t)
+	decrypter, err := crypt.New(crypt.WithPrivateKey(privatePath))
+	require.NoError(t, err)
+	secret, token, err := auth.Generate("app", auth.ScopeIngest)
+	require.NoError(t, err)
+	tokens, err := auth.NewStore(token)
+	require.NoError(t, err)
+
+	storage := repository.NewMemStorage()
+	server := httptest.NewServer(utils.NewRouter(
+		storage, keyring, nil, nil,
+		utils.WithDecryption(decrypter),
+		utils.WithSignatureVerifier(signature.NewVerifier(keyring, signature.DefaultWindow, true)),
+		utils.WithTokens(tokens),
+	))
+	defer server.Close()
+
+	c, err := New(server.Listener.Addr().String(), WithKeys(testKey), WithPublicKey(publicPath), WithToken(secret))
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Gauge("Queue", 1)
+	c.Gauge("Queue", 12)
+	c.Counter("Requests", 2)
+	c.Counter("Requests", 3)
+	require.NoError(t, c.Flush(context.Background()))
+	requireMetrics(t, storage, map[string]metrics.Metric{
+		"Queue":    metrics.NewMetricGauge("Queue", 12),
+		"Requests": metrics.NewMetricCounter("Requests", 5),
+	})
+	sent := c.BytesSent()
+	assert.Greater(t, sent, uint64(0))
+
+	require.NoError(t, c.Push(context.Background(), []Metric{NewCounter("Requests", 1)}))
+	require.NoError(t, c.Flush(context.Background()), "empty flush must succeed")
+	assert.Greater(t, c.BytesSent(), sent, "every sent request must be counted")
+	requireMetrics(t, storage, map[string]metrics.Metric{
+		"Queue":    metrics.NewMetricGauge("Queue", 12),
+		"Requests": metrics.NewMetricCounter("Requests", 6),
+	})
+
+	large := make([]Metric, 0, 100)
+	for i := 0; i < 100; i++ {
+		large = append(large, NewGauge(fmt.Sprintf("Large%d", i), float64(i)))
+	}
+	require.NoError(t, c.Push(context.Background(), large), "large batch must be compressed before encryption")
+	stored, err := storage.GetMetric(context.Background(), "Large99")
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Gauge(99), stored.GetGaugeValue())
+
+	unsigned, err := New(server.Listener.Addr().String(), WithPublicKey(publicPath), WithToken(secret))
+	require.NoError(t, err)
+	defer unsigned.Close()
+	err = unsigned.Push(context.Background(), []Metric{NewGauge("Queue", 1)})
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "invalid_signature", rejected.Code)
+}
+
+func TestClient_FlushRetry(t *testing.T) {
+	limited := true
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if limited {
+			rw.Header().Set("Retry-After", "30")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	c, err := New(server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Counter("Requests", 1)
+	c.Gauge("Queue", 1)
+	err = c.Flush(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 30*time.Second, RetryAfter(err))
+
+	limited = false
+	c.Counter("Requests", 2)
+	c.Gauge("Queue", 5)
+	require.NoError(t, c.Flush(context.Background()))
+	require.Len(t, bodies, 1)
+	assert.Contains(t, bodies[0], `"id":"Requests","type":"counter","delta":3`, "failed counters must be pushed with the next flush")
+	assert.Contains(t, bodies[0], `"id":"Queue","type":"gauge","value":5`, "the last gauge value must win")
+}
+
+func TestClient_GRPC(t *testing.T) {
+	keyring := testutil.Keyring(t, hashKeys([]Key{testKey})...)
+	storage := repository.NewMemStorage()
+
+	var keyIDs []string
+	recordKeyID := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		keyIDs = append(keyIDs, md.Get(hash.KeyIDHeader)...)
+		return handler(ctx, req)
+	}
+
+	verifier := signature.NewVerifier(keyring, signature.DefaultWindow, true)
+	server := grpcserver.New(storage, keyring, grpc.ChainUnaryInterceptor(recordKeyID, verifier.UnaryServerInterceptor()))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	c, err := New(listener.Addr().String(), WithGRPC(), WithKeys(testKey), WithHashAlgorithm(HashSHA512))
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Gauge("Queue", 7)
+	c.Counter("Requests", 4)
+	require.NoError(t, c.Flush(context.Background()))
+	requireMetrics(t, storage, map[string]metrics.Metric{
+		"Queue":    metrics.NewMetricGauge("Queue", 7),
+		"Requests": metrics.NewMetricCounter("Requests", 4),
+	})
+	assert.Greater(t, c.BytesSent(), uint64(0))
+	assert.Equal(t, []string{testKey.ID}, keyIDs, "hashes must be checked with the key that signed them")
+
+	err = c.Push(context.Background(), []Metric{{Name: "Broken", Kind: "histogram"}})
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "Unsupported hash algorithm", opts: []Option{WithHashAlgorithm("md5")}},
+		{name: "Duplicate key IDs", opts: []Option{WithKeys(testKey, testKey)}},
+		{name: "Encryption over gRPC", opts: []Option{WithGRPC(), WithPublicKey("public.pem")}},
+		{name: "Missing public key", opts: []Option{WithPublicKey(filepath.Join(t.TempDir(), "public.pem"))}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New("localhost:8080", tt.opts...)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "Seconds", value: "7", want: 7 * time.Second},
+		{name: "Http date", value: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute},
+		{name: "Past date", value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "Empty", value: "", want: 0},
+		{name: "Garbage", value: "soon", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.value, now))
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"github.com/VladimirMovsesyan/praktikum-devops/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"strings"
+	"time"
+)
+
+// grpcTransport - pushes batches with UpdateMetrics call of MetricsCollection service.
+type grpcTransport struct {
+	conn    *grpc.ClientConn
+	client  proto.MetricsCollectionClient
+	keys    *hash.Keyring
+	alg     hash.Algorithm
+	agentID string
+}
+
+func newGRPCTransport(address string, keys *hash.Keyring, alg hash.Algorithm, o options) (*grpcTransport, error) {
+	creds := insecure.NewCredentials()
+	if o.tlsConfig != nil {
+		creds = credentials.NewTLS(o.tlsConfig)
+	}
+
+	sign := signature.UnaryClientInterceptor(keys, alg)
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// Keys may be set after dialing, so requests are signed only while keyring isn't empty.
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if !keys.Enabled() {
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+			return sign(ctx, method, req, reply, cc, invoker, opts...)
+		}),
+	}
+	if o.token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(auth.BearerCredentials(o.token, o.tlsConfig != nil)))
+	}
+
+	conn, err := grpc.Dial(address, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcTransport{
+		conn:    conn,
+		client:  proto.NewMetricsCollectionClient(conn),
+		keys:    keys,
+		alg:     alg,
+		agentID: o.agentID,
+	}, nil
+}
+
+func (t *grpcTransport) push(ctx context.Context, batch []metrics.Metric) (int, error) {
+	request := &proto.BatchUpdateMetricsRequest{Metrics: make([]*proto.Metrics, 0, len(batch))}
+	var keyID string
+	for _, metric := range batch {
+		m := &proto.Metrics{ID: metric.GetName()}
+		switch metric.GetKind() {
+		case KindGauge:
+			m.MType = proto.Metrics_GAUGE
+			m.Value = float64(metric.GetGaugeValue())
+		case KindCounter:
+			m.MType = proto.Metrics_COUNTER
+			m.Delta = int64(metric.GetCounterValue())
+		}
+
+		if t.keys.Enabled() {
+			hashData, err := t.alg.MetricData(metric)
+			if err != nil {
+				return 0, err
+			}
+
+			m.Hash, keyID, err = t.keys.Sign(t.alg, hashData)
+			if err != nil {
+				return 0, err
+			}
+		}
+		request.Metrics = append(request.Metrics, m)
+	}
+
+	var pairs []string
+	if t.keys.Enabled() && t.alg != hash.Legacy {
+		pairs = append(pairs, strings.ToLower(hash.AlgorithmHeader), string(t.alg))
+	}
+	if keyID != "" {
+		pairs = append(pairs, strings.ToLower(hash.KeyIDHeader), keyID)
+	}
+	if t.agentID != "" {
+		pairs = append(pairs, strings.ToLower(wire.AgentIDHeader), t.agentID)
+	}
+	if len(pairs) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
+	}
+
+	var header metadata.MD
+	_, err := t.client.UpdateMetrics(ctx, request, grpc.Header(&header))
+	if err == nil {
+		return protobuf.Size(request), nil
+	}
+
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.DeadlineExceeded || s.Code() == codes.Canceled {
+		return 0, err
+	}
+
+	rejected := &RejectedError{Status: s.Code().String(), Message: s.Message(), Code: errorCode(s)}
+	if values := header.Get("retry-after"); len(values) > 0 && (s.Code() == codes.ResourceExhausted || s.Code() == codes.Unavailable) {
+		rejected.RetryAfter = parseRetryAfter(values[0], time.Now())
+	}
+	return protobuf.Size(request), rejected
+}
+
+func (t *grpcTransport) close() error {
+	return t.conn.Close()
+}
+
+// errorCode - returns error code of server from details of status, empty if server sent none.
+func errorCode(s *status.Status) string {
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == wire.ErrorDomain {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/auth"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/crypt"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/hash"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/metrics"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/signature"
+	"github.com/VladimirMovsesyan/praktikum-devops/internal/wire"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProtocol = "http://"
+	tlsProtocol     = "https://"
+	updatesPath     = "/updates/"
+
+	// maxErrorSize - limit of read error response.
+	maxErrorSize = 4 << 10
+)
+
+// gzipWriters - writers reused by uploads, agent compresses with the best speed to keep its CPU usage low.
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+// httpTransport - pushes batches as json to /updates/.
+type httpTransport struct {
+	client  *http.Client
+	url     string
+	keys    *hash.Keyring
+	alg     hash.Algorithm
+	crypter crypt.Crypter
+	agentID string
+	// gzipThreshold - bodies of at least this size are compressed, non-positive threshold disables compression.
+	gzipThreshold int
+}
+
+func newHTTPTransport(address string, keys *hash.Keyring, alg hash.Algorithm, o options) (*httpTransport, error) {
+	t := &httpTransport{
+		client:        &http.Client{},
+		url:           defaultProtocol + address + updatesPath,
+		keys:          keys,
+		alg:           alg,
+		agentID:       o.agentID,
+		gzipThreshold: o.gzipThreshold,
+	}
+
+	if o.tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = o.tlsConfig
+		t.client.Transport = transport
+		t.url = tlsProtocol + address + updatesPath
+	}
+	if o.token != "" {
+		t.client.Transport = auth.BearerTransport(o.token, t.client.Transport)
+	}
+
+	if o.publicKey != "" {
+		var err error
+		t.crypter, err = crypt.New(crypt.WithPublicKey(o.publicKey))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func (t *httpTransport) push(ctx context.Context, batch []metrics.Metric) (int, error) {
+	jsonMetrics := make([]wire.Metric, 0, len(batch))
+	for _, metric := range batch {
+		jsonMetric := toWire(metric)
+
+		if t.keys.Enabled() {
+			hashData, err := t.alg.MetricData(metric)
+			if err != nil {
+				return 0, err
+			}
+
+			jsonMetric.Hash, jsonMetric.KeyID, err = t.keys.Sign(t.alg, hashData)
+			if err != nil {
+				return 0, err
+			}
+			jsonMetric.HashAlgorithm = string(t.alg)
+		}
+		jsonMetrics = append(jsonMetrics, jsonMetric)
+	}
+
+	// Request is signed over plain body, server checks signature after decryption.
+	plain, err := json.Marshal(&jsonMetrics)
+	if err != nil {
+		return 0, err
+	}
+
+	// Body is compressed before encryption, server decrypts it before decompression.
+	body := plain
+	compressed := t.gzipThreshold > 0 && len(plain) >= t.gzipThreshold
+	if compressed {
+		body, err = compress(plain)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Never fall back to plain body: server configured for encryption rejects it anyway.
+	if t.crypter != nil {
+		body, err = t.crypter.Encrypt(body)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if t.keys.Enabled() {
+		err = signature.Sign(req, plain, t.keys, t.alg)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if t.crypter != nil {
+		req.Header.Set(crypt.SchemeHeader, crypt.Scheme)
+	}
+	if t.agentID != "" {
+		req.Header.Set(wire.AgentIDHeader, t.agentID)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return len(body), nil
+	}
+
+	rejected := &RejectedError{Status: resp.Status}
+	var response wire.ErrorResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	if json.Unmarshal(data, &response) == nil && response.Code != "" {
+		rejected.Code, rejected.Message = response.Code, response.Message
+	} else {
+		rejected.Message = string(bytes.TrimSpace(data))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		rejected.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return len(body), rejected
+}
+
+// toWire - converts metric to json representation, metrics of batch are built by client, so their kind is valid.
+func toWire(metric metrics.Metric) wire.Metric {
+	m := wire.Metric{ID: metric.GetName(), MType: metric.GetKind()}
+	if metric.GetKind() == KindCounter {
+		delta := int64(metric.GetCounterValue())
+		m.Delta = &delta
+	} else {
+		value := float64(metric.GetGaugeValue())
+		m.Value = &value
+	}
+	return m
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseRetryAfter - parses Retry-After header given in seconds or as http date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func (t *httpTransport) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}